@@ -0,0 +1,45 @@
+package main
+
+// 大输出 claim-check：Worker 的 callback Output 超过 Step Functions 上限时被转存到 S3，执行输出中只有 outputRef 指针
+// （见 cmd/worker/claimcheck.go 与 internal/claimcheck）。API 返回前透明地解引用，响应中的 output 始终是完整内容，outputRef 标明来源。
+// S3_ENDPOINT_URL 可指向本地 S3 兼容服务（path-style）。
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"testsqs/internal/claimcheck"
+)
+
+// resolveOutputRef 在 Output 带 outputRef 时从 S3 取回完整 Output；取回失败时保留指针并在 Error 中说明。
+func resolveOutputRef(ctx context.Context, resp apiResponse) apiResponse {
+	if len(resp.Output) == 0 {
+		return resp
+	}
+	ref := claimcheck.OutputRefOf(resp.Output)
+	if ref == nil {
+		return resp
+	}
+	resp.OutputRef = ref
+
+	b, err := fetchOutputRef(ctx, ref)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Output = b
+	return resp
+}
+
+// fetchOutputRef 从 S3 取回 outputRef 指向的完整 Output（必须是合法 JSON）。
+func fetchOutputRef(ctx context.Context, ref *claimcheck.PayloadRef) (json.RawMessage, error) {
+	b, err := claimcheck.Fetch(ctx, s3Client, ref)
+	if err != nil {
+		return nil, fmt.Errorf("resolve outputRef: %v", err)
+	}
+	if !json.Valid(b) {
+		return nil, fmt.Errorf("resolve outputRef s3://%s/%s: invalid content", ref.Bucket, ref.Key)
+	}
+	return json.RawMessage(b), nil
+}
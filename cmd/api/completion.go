@@ -0,0 +1,134 @@
+package main
+
+// 完成通知（completion record）：Worker 回调 SendTaskSuccess/SendTaskFailure 后，会向 DynamoDB 写入一条
+// id="run#<runId>" 的完成记录（键见 internal/tasktable）。
+// ddb 模式下 API 以指数退避（见 pollBackoff）读取该记录，而不是轮询 DescribeExecution：
+//   - 不消耗 Step Functions API 配额；
+//   - 初始间隔短（默认 20ms），短任务读到记录的时刻紧跟 Worker 完成。
+// TotalMs 始终以本函数所在主机的时钟计算（API 开始到读到记录）；Worker 记录的完成时间戳来自另一台主机的时钟，
+// 两者相减会混入时钟偏差，因此只作为 workerCompletedUnixNano 单独返回。
+// Worker 上报的失败同样会写记录（status=FAILED）；记录迟迟不出现（中止/超时的执行不会写记录）时，按 COMPLETION_FALLBACK_MS 间隔回退到 DescribeExecution。
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+
+	"testsqs/internal/env"
+	"testsqs/internal/tasktable"
+)
+
+const (
+	completionModePoll = "poll"
+	completionModeDdb  = "ddb"
+)
+
+// completionMode 以请求字段优先，其次是 COMPLETION_MODE 环境变量；未知取值按 poll 处理。
+func completionMode(requested string) string {
+	mode := strings.ToLower(strings.TrimSpace(requested))
+	if mode == "" {
+		mode = strings.ToLower(strings.TrimSpace(os.Getenv("COMPLETION_MODE")))
+	}
+	if mode == completionModeDdb {
+		return completionModeDdb
+	}
+	return completionModePoll
+}
+
+// waitForCompletionRecord 等待 Worker 写入的完成记录；每隔 fallback 间隔同时检查一次执行状态。
+func waitForCompletionRecord(ctx context.Context, runID, execArn string, start time.Time) (int, apiResponse) {
+	tableName := strings.TrimSpace(os.Getenv("TABLE_NAME"))
+	if tableName == "" {
+		// 未配置表时无法等待记录：直接退化为轮询。
+		return pollExecution(ctx, execArn, start, false)
+	}
+
+	interval, maxInterval := pollBackoff()
+	fallbackEvery := time.Duration(env.Int("COMPLETION_FALLBACK_MS", 2000)) * time.Millisecond
+	lastDescribe := time.Now()
+
+	for {
+		if ctx.Err() != nil {
+			elapsed := time.Since(start).Milliseconds()
+			return 504, apiResponse{ExecutionArn: execArn, TotalMs: elapsed, Status: "TIMEOUT", Error: ctx.Err().Error(), Completion: completionModeDdb}
+		}
+
+		out, err := ddbClient.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(tableName),
+			ConsistentRead: aws.Bool(true),
+			Key: map[string]dynamodbtypes.AttributeValue{
+				"id": &dynamodbtypes.AttributeValueMemberS{Value: tasktable.CompletionRecordKey(runID)},
+			},
+		})
+		if err != nil {
+			// 读记录失败不影响正确性：退化为轮询。
+			return pollExecution(ctx, execArn, start, false)
+		}
+		if len(out.Item) > 0 {
+			resp := completionResponse(out.Item, execArn, start)
+			return statusCodeFor(sfntypes.ExecutionStatus(resp.Status)), resolveOutputRef(ctx, resp)
+		}
+
+		if time.Since(lastDescribe) >= fallbackEvery {
+			lastDescribe = time.Now()
+			desc, err := sfnClient.DescribeExecution(ctx, &sfn.DescribeExecutionInput{ExecutionArn: aws.String(execArn)})
+			if err != nil {
+				elapsed := time.Since(start).Milliseconds()
+				return 502, apiResponse{ExecutionArn: execArn, TotalMs: elapsed, Status: "ERROR", Error: fmt.Sprintf("describe execution: %v", err)}
+			}
+			if isTerminalStatus(desc.Status) {
+				resp := executionResponse(desc)
+				resp.TotalMs = time.Since(start).Milliseconds()
+				resp.Completion = completionModePoll
+				return statusCodeFor(desc.Status), resolveOutputRef(ctx, resp)
+			}
+		}
+
+		time.Sleep(interval)
+		interval = min(interval*2, maxInterval)
+	}
+}
+
+// pollBackoff 返回等待完成时的初始轮询间隔与上限（COMPLETION_POLL_INITIAL_MS / COMPLETION_POLL_MAX_MS），
+// ddb 模式读记录与 DescribeExecution 轮询共用，间隔每次翻倍直到上限。
+func pollBackoff() (time.Duration, time.Duration) {
+	interval := time.Duration(env.Int("COMPLETION_POLL_INITIAL_MS", 20)) * time.Millisecond
+	maxInterval := time.Duration(env.Int("COMPLETION_POLL_MAX_MS", 250)) * time.Millisecond
+	return interval, max(maxInterval, interval)
+}
+
+// completionResponse 把完成记录映射为 apiResponse；output 与执行的 Output 相同（即 Worker 的 callback Output）。
+// TotalMs 为 start 到读到记录的本地耗时，记录中的 completedUnixNano（Worker 时钟）原样放入 WorkerCompletedUnixNano。
+// Worker 上报失败时 status=FAILED，并带有错误码（error）与原因（cause）。
+func completionResponse(item map[string]dynamodbtypes.AttributeValue, execArn string, start time.Time) apiResponse {
+	resp := apiResponse{ExecutionArn: execArn, Status: string(sfntypes.ExecutionStatusSucceeded), Completion: completionModeDdb}
+	if v, ok := item["status"].(*dynamodbtypes.AttributeValueMemberS); ok && v.Value != "" {
+		resp.Status = v.Value
+	}
+	if v, ok := item["output"].(*dynamodbtypes.AttributeValueMemberS); ok && json.Valid([]byte(v.Value)) {
+		resp.Output = json.RawMessage(v.Value)
+	}
+	if v, ok := item["error"].(*dynamodbtypes.AttributeValueMemberS); ok {
+		resp.ErrorCode = v.Value
+	}
+	if v, ok := item["cause"].(*dynamodbtypes.AttributeValueMemberS); ok {
+		resp.Error = v.Value
+	}
+	resp.TotalMs = time.Since(start).Milliseconds()
+	if v, ok := item["completedUnixNano"].(*dynamodbtypes.AttributeValueMemberN); ok {
+		if n, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			resp.WorkerCompletedUnixNano = n
+		}
+	}
+	return resp
+}
@@ -0,0 +1,135 @@
+package main
+
+// Express workflow：使用 StartSyncExecution 同步等待，无需 DescribeExecution 轮询。
+// 注意：Express 不支持 waitForTaskToken，因此 Express 状态机以 request-response 方式调用 Dispatcher，
+// 由 Dispatcher 等待 Worker 写入的完成记录（见 cmd/dispatcher 的 waitForCompletion）。
+// Express 执行无法通过 DescribeExecution/StopExecution 查询或中止，因此不支持 async 与 /runs/{id}。
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+)
+
+const (
+	workflowTypeStandard = string(sfntypes.StateMachineTypeStandard)
+	workflowTypeExpress  = string(sfntypes.StateMachineTypeExpress)
+)
+
+// billingDetails 对应 StartSyncExecution 返回的 BillingDetails（仅 Express）。
+type billingDetails struct {
+	BilledDurationMs int64 `json:"billedDurationMs"`
+	BilledMemoryMB   int64 `json:"billedMemoryMB"`
+}
+
+var (
+	// 状态机类型探测结果（按 ARN 缓存，热启动复用）。
+	workflowTypeMu    sync.Mutex
+	workflowTypeCache = map[string]string{}
+)
+
+// resolveStateMachine 选择本次请求使用的状态机及其类型：
+//   - 请求 workflowType=EXPRESS 且配置了 EXPRESS_STATE_MACHINE_ARN 时使用 Express 状态机；
+//   - 否则使用 STATE_MACHINE_ARN，类型取 WORKFLOW_TYPE 环境变量，未配置时通过 DescribeStateMachine 探测。
+func resolveStateMachine(ctx context.Context, smArn, requested string) (string, string, error) {
+	requested = strings.ToUpper(strings.TrimSpace(requested))
+	if requested != "" && requested != workflowTypeStandard && requested != workflowTypeExpress {
+		return "", "", fmt.Errorf("invalid workflowType %q", requested)
+	}
+	if requested == workflowTypeExpress {
+		if expressArn := strings.TrimSpace(os.Getenv("EXPRESS_STATE_MACHINE_ARN")); expressArn != "" {
+			return expressArn, workflowTypeExpress, nil
+		}
+	}
+
+	wfType := strings.ToUpper(strings.TrimSpace(os.Getenv("WORKFLOW_TYPE")))
+	if wfType != workflowTypeStandard && wfType != workflowTypeExpress {
+		wfType = detectWorkflowType(ctx, smArn)
+	}
+	if requested != "" && requested != wfType {
+		return "", "", fmt.Errorf("workflowType %s not available (state machine is %s)", requested, wfType)
+	}
+	return smArn, wfType, nil
+}
+
+// detectWorkflowType 通过 DescribeStateMachine 获取状态机类型；失败时按 STANDARD 处理（不缓存）。
+func detectWorkflowType(ctx context.Context, smArn string) string {
+	workflowTypeMu.Lock()
+	defer workflowTypeMu.Unlock()
+	if t, ok := workflowTypeCache[smArn]; ok {
+		return t
+	}
+	out, err := sfnClient.DescribeStateMachine(ctx, &sfn.DescribeStateMachineInput{StateMachineArn: aws.String(smArn)})
+	if err != nil {
+		return workflowTypeStandard
+	}
+	t := string(out.Type)
+	if t != workflowTypeExpress {
+		t = workflowTypeStandard
+	}
+	workflowTypeCache[smArn] = t
+	return t
+}
+
+// expressDispatchTimeout 返回 Express 执行 Dispatch 任务的超时（秒），即本次请求的等待时间 maxWait。
+// 延迟加模拟工作已经达到 maxWait 时执行不可能在同步调用内完成，返回错误（API 以 400 拒绝）。
+func expressDispatchTimeout(delaySeconds int, workMs int64, maxWait time.Duration) (int, error) {
+	need := time.Duration(delaySeconds)*time.Second + time.Duration(workMs)*time.Millisecond
+	if need >= maxWait {
+		return 0, fmt.Errorf("delaySeconds=%d and workMs=%d need at least %s but EXPRESS runs synchronously within %s; use a STANDARD workflow (optionally async) instead",
+			delaySeconds, workMs, need, maxWait)
+	}
+	return max(int(maxWait/time.Second), 1), nil
+}
+
+// runExpress 使用 StartSyncExecution 执行 Express 状态机，并把 SyncExecutionStatus 与计费信息映射为 apiResponse。
+func runExpress(ctx context.Context, smArn, execName string, input []byte) (int, apiResponse) {
+	start := time.Now()
+	out, err := sfnClient.StartSyncExecution(ctx, &sfn.StartSyncExecutionInput{
+		StateMachineArn: aws.String(smArn),
+		Name:            aws.String(execName),
+		Input:           aws.String(string(input)),
+	})
+	elapsed := time.Since(start).Milliseconds()
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return 504, apiResponse{TotalMs: elapsed, Status: "TIMEOUT", Error: err.Error(), WorkflowType: workflowTypeExpress}
+		}
+		return 502, apiResponse{TotalMs: elapsed, Status: "ERROR", Error: fmt.Sprintf("start sync execution: %v", err), WorkflowType: workflowTypeExpress}
+	}
+
+	resp := apiResponse{
+		ExecutionArn: aws.ToString(out.ExecutionArn),
+		Status:       string(out.Status),
+		TotalMs:      elapsed,
+		WorkflowType: workflowTypeExpress,
+	}
+	if out.BillingDetails != nil {
+		resp.Billing = &billingDetails{
+			BilledDurationMs: out.BillingDetails.BilledDurationInMilliseconds,
+			BilledMemoryMB:   out.BillingDetails.BilledMemoryUsedInMB,
+		}
+	}
+	if out.Status == sfntypes.SyncExecutionStatusSucceeded {
+		if out.Output != nil {
+			resp.Output = json.RawMessage([]byte(aws.ToString(out.Output)))
+		}
+		return 200, resolveOutputRef(ctx, resp)
+	}
+	msg := aws.ToString(out.Cause)
+	if msg == "" {
+		msg = aws.ToString(out.Error)
+	}
+	resp.Error = msg
+	resp.ErrorCode = aws.ToString(out.Error)
+	return 500, resp
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpressDispatchTimeout(t *testing.T) {
+	cases := []struct {
+		name         string
+		delaySeconds int
+		workMs       int64
+		maxWait      time.Duration
+		want         int
+		wantErr      bool
+	}{
+		{name: "no work", maxWait: 25 * time.Second, want: 25},
+		{name: "fits", delaySeconds: 2, workMs: 5000, maxWait: 25 * time.Second, want: 25},
+		{name: "sub-second budget", maxWait: 400 * time.Millisecond, want: 1},
+		{name: "rounds down", workMs: 100, maxWait: 10500 * time.Millisecond, want: 10},
+		{name: "work equals budget", workMs: 25000, maxWait: 25 * time.Second, wantErr: true},
+		{name: "delay plus work exceeds", delaySeconds: 10, workMs: 16000, maxWait: 25 * time.Second, wantErr: true},
+		{name: "delay alone exceeds", delaySeconds: 30, maxWait: 25 * time.Second, wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := expressDispatchTimeout(tc.delaySeconds, tc.workMs, tc.maxWait)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("expressDispatchTimeout(%s) error = %v, wantErr %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && got != tc.want {
+			t.Errorf("expressDispatchTimeout(%s) = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+// Map 状态扇出（fan-out）：请求 fanout=N 时启动 FANOUT_STATE_MACHINE_ARN（template.yaml 中的 TestFanoutStateMachine），
+// 由 Map 状态并行执行 N 个 Dispatch 分支（每个分支有自己的 taskToken 与消息），执行输出是按分支顺序排列的 N 个 Worker Output。
+// API 返回前按分支汇总耗时：各分支以执行开始时间（StartDate）为基准，最慢的分支（straggler）决定整体延迟。
+// 只支持 Standard workflow；ddb 完成记录以 runId 为键、无法区分分支，因此扇出执行始终以 DescribeExecution 轮询判定完成。
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"testsqs/internal/claimcheck"
+)
+
+// maxFanout：inline Map 状态的最大并发分支数。
+const maxFanout = 40
+
+// branchTiming 是单个分支的耗时（毫秒）：
+//   - DispatchMs：执行开始到 Dispatcher 开始发送（Map 启动分支 + 调用 Dispatcher）；
+//   - DeliverMs：Dispatcher 开始发送到 Worker 接收；
+//   - WorkerMs：Worker 处理耗时；
+//   - TotalMs：执行开始到 Worker 发起回调。
+type branchTiming struct {
+	Branch     int    `json:"branch"`
+	ID         string `json:"id,omitempty"`
+	DispatchMs int64  `json:"dispatchMs"`
+	DeliverMs  int64  `json:"deliverMs"`
+	WorkerMs   int64  `json:"workerMs"`
+	TotalMs    int64  `json:"totalMs"`
+}
+
+// fanoutSummary 汇总所有分支的 TotalMs；StragglerBranch 为最慢的分支，StragglerMs 为它比中位数慢的时间。
+type fanoutSummary struct {
+	Branches        int            `json:"branches"`
+	MinMs           int64          `json:"minMs"`
+	AvgMs           float64        `json:"avgMs"`
+	P50Ms           int64          `json:"p50Ms"`
+	MaxMs           int64          `json:"maxMs"`
+	StragglerBranch int            `json:"stragglerBranch"`
+	StragglerMs     int64          `json:"stragglerMs"`
+	Timings         []branchTiming `json:"timings"`
+}
+
+// branchOutput 是从分支 Output（Worker 的 callback Output）中读取的时间戳。
+type branchOutput struct {
+	ID                      string                 `json:"id"`
+	SendStartUnixNano       int64                  `json:"sendStartUnixNano"`
+	ReceiveUnixNano         int64                  `json:"receiveUnixNano"`
+	WorkerDoneUnixNano      int64                  `json:"workerDoneUnixNano"`
+	CallbackRequestUnixNano int64                  `json:"callbackRequestUnixNano"`
+	OutputRef               *claimcheck.PayloadRef `json:"outputRef"`
+}
+
+// fanoutStateMachine 返回扇出状态机 ARN；扇出只支持 Standard workflow。
+func fanoutStateMachine(requestedWorkflowType string) (string, string, error) {
+	if wf := strings.ToUpper(strings.TrimSpace(requestedWorkflowType)); wf != "" && wf != workflowTypeStandard {
+		return "", "", fmt.Errorf("fanout is only supported for %s workflows", workflowTypeStandard)
+	}
+	smArn := strings.TrimSpace(os.Getenv("FANOUT_STATE_MACHINE_ARN"))
+	if smArn == "" {
+		return "", "", fmt.Errorf("fanout requested but env FANOUT_STATE_MACHINE_ARN is not configured")
+	}
+	return smArn, workflowTypeStandard, nil
+}
+
+// fanoutBranches 生成 Map 状态的 ItemsPath 数组（分支序号 0..n-1）。
+func fanoutBranches(n int) []int {
+	branches := make([]int, n)
+	for i := range branches {
+		branches[i] = i
+	}
+	return branches
+}
+
+// resolveFanout 在 Output 是分支数组时解引用各分支的 outputRef，并按 started（执行开始时间）汇总分支耗时。
+func resolveFanout(ctx context.Context, resp apiResponse, started *time.Time) apiResponse {
+	if len(resp.Output) == 0 || started == nil {
+		return resp
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(resp.Output, &items); err != nil || len(items) == 0 {
+		return resp
+	}
+
+	base := started.UnixNano()
+	summary := &fanoutSummary{Branches: len(items), Timings: make([]branchTiming, 0, len(items))}
+	for i, item := range items {
+		var out branchOutput
+		_ = json.Unmarshal(item, &out)
+		// Worker 的 Output 超过阈值时被转存到 S3：取回完整内容，分支数组中同样替换为完整 Output。
+		if out.OutputRef != nil {
+			full, err := fetchOutputRef(ctx, out.OutputRef)
+			if err != nil {
+				resp.Error = err.Error()
+			} else {
+				items[i] = full
+				out = branchOutput{}
+				_ = json.Unmarshal(full, &out)
+			}
+		}
+		summary.Timings = append(summary.Timings, branchTiming{
+			Branch:     i,
+			ID:         out.ID,
+			DispatchMs: elapsedMs(base, out.SendStartUnixNano),
+			DeliverMs:  elapsedMs(out.SendStartUnixNano, out.ReceiveUnixNano),
+			WorkerMs:   elapsedMs(out.ReceiveUnixNano, out.WorkerDoneUnixNano),
+			TotalMs:    elapsedMs(base, out.CallbackRequestUnixNano),
+		})
+	}
+	if b, err := json.Marshal(items); err == nil {
+		resp.Output = b
+	}
+
+	totals := make([]int64, len(summary.Timings))
+	var sum int64
+	for i, bt := range summary.Timings {
+		totals[i] = bt.TotalMs
+		sum += bt.TotalMs
+		if bt.TotalMs > summary.Timings[summary.StragglerBranch].TotalMs {
+			summary.StragglerBranch = i
+		}
+	}
+	sort.Slice(totals, func(a, b int) bool { return totals[a] < totals[b] })
+	summary.MinMs = totals[0]
+	summary.MaxMs = totals[len(totals)-1]
+	summary.AvgMs = float64(sum) / float64(len(totals))
+	summary.P50Ms = totals[(len(totals)-1)/2]
+	summary.StragglerMs = summary.MaxMs - summary.P50Ms
+	resp.Fanout = summary
+	return resp
+}
+
+// elapsedMs 返回 from 到 to（UnixNano）的毫秒数；任一时间戳缺失或时钟偏差导致为负时返回 0。
+func elapsedMs(from, to int64) int64 {
+	if from <= 0 || to <= 0 || to < from {
+		return 0
+	}
+	return (to - from) / int64(time.Millisecond)
+}
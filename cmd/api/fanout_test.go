@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+
+	"testsqs/internal/claimcheck"
+)
+
+// branchJSON 生成一个分支 Output：各时间戳以执行开始时间 base 加毫秒偏移表示。
+func branchJSON(id string, base time.Time, sendMs, receiveMs, doneMs, callbackMs int) string {
+	at := func(ms int) int64 { return base.Add(time.Duration(ms) * time.Millisecond).UnixNano() }
+	return fmt.Sprintf(`{"id":%q,"sendStartUnixNano":%d,"receiveUnixNano":%d,"workerDoneUnixNano":%d,"callbackRequestUnixNano":%d}`,
+		id, at(sendMs), at(receiveMs), at(doneMs), at(callbackMs))
+}
+
+func TestResolveFanout(t *testing.T) {
+	started := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	// 分支 2 的 Output 被 Worker 转存到 S3，执行输出中只有 outputRef 指针。
+	offloaded := branchJSON("m-2", started, 30, 80, 500, 520)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/payloads/outputs/m-2.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(offloaded))
+	}))
+	defer srv.Close()
+	t.Setenv("S3_ENDPOINT_URL", srv.URL)
+	prev := s3Client
+	s3Client = claimcheck.NewS3Client(aws.Config{Region: "us-east-1", Credentials: credentials.NewStaticCredentialsProvider("test", "test", "")})
+	defer func() { s3Client = prev }()
+
+	output := "[" + branchJSON("m-0", started, 10, 40, 140, 150) + "," +
+		branchJSON("m-1", started, 20, 60, 190, 200) + "," +
+		`{"id":"m-2","runId":"r-1","outputRef":{"bucket":"payloads","key":"outputs/m-2.json","bytes":10}}` + "," +
+		branchJSON("m-3", started, 15, 45, 160, 170) + "]"
+	resp := resolveFanout(context.Background(), apiResponse{Output: json.RawMessage(output)}, &started)
+	if resp.Error != "" {
+		t.Fatalf("resolveFanout error: %s", resp.Error)
+	}
+	f := resp.Fanout
+	if f == nil || f.Branches != 4 || len(f.Timings) != 4 {
+		t.Fatalf("fanout summary = %+v", f)
+	}
+	// 分支 TotalMs 为 150/200/520/170：p50 取下中位数 170，straggler 为分支 2，比中位数慢 350ms。
+	if f.MinMs != 150 || f.MaxMs != 520 || f.P50Ms != 170 || f.AvgMs != 260 || f.StragglerBranch != 2 || f.StragglerMs != 350 {
+		t.Fatalf("fanout summary = %+v", *f)
+	}
+	want := branchTiming{Branch: 2, ID: "m-2", DispatchMs: 30, DeliverMs: 50, WorkerMs: 420, TotalMs: 520}
+	if f.Timings[2] != want {
+		t.Fatalf("offloaded branch timing = %+v, want %+v", f.Timings[2], want)
+	}
+
+	// 输出数组中转存的分支被替换为完整 Output。
+	var items []json.RawMessage
+	if err := json.Unmarshal(resp.Output, &items); err != nil || len(items) != 4 || string(items[2]) != offloaded {
+		t.Fatalf("resolved output = %s (err %v)", resp.Output, err)
+	}
+}
+
+func TestResolveFanoutPassthrough(t *testing.T) {
+	started := time.Now()
+	cases := []struct {
+		name    string
+		output  string
+		started *time.Time
+	}{
+		{name: "no output", output: "", started: &started},
+		{name: "not an array", output: `{"id":"m-1"}`, started: &started},
+		{name: "empty array", output: `[]`, started: &started},
+		{name: "no start time", output: `[{"id":"m-1"}]`, started: nil},
+	}
+	for _, tc := range cases {
+		in := apiResponse{Output: json.RawMessage(tc.output)}
+		got := resolveFanout(context.Background(), in, tc.started)
+		if got.Fanout != nil || string(got.Output) != tc.output {
+			t.Errorf("resolveFanout(%s) = output %s fanout %+v, want unchanged", tc.name, got.Output, got.Fanout)
+		}
+	}
+
+	// 缺失或倒序的时间戳（时钟偏差）记为 0，而不是负数。
+	resp := resolveFanout(context.Background(), apiResponse{Output: json.RawMessage(`[{"id":"m-1","sendStartUnixNano":5}]`)}, &started)
+	if resp.Fanout == nil || resp.Fanout.Timings[0] != (branchTiming{ID: "m-1"}) {
+		t.Errorf("timings with missing timestamps = %+v", resp.Fanout)
+	}
+}
+
+func TestRunExecutionArnsFanout(t *testing.T) {
+	const fanoutArn = "arn:aws:states:us-east-1:123456789012:stateMachine:TestFanoutStateMachine"
+	t.Setenv("FANOUT_STATE_MACHINE_ARN", fanoutArn)
+	got, ok := runExecutionArns(testStateMachineArn, "run-1")
+	want := []string{
+		"arn:aws:states:us-east-1:123456789012:execution:TestStateMachine:run-1",
+		"arn:aws:states:us-east-1:123456789012:execution:TestFanoutStateMachine:run-1",
+	}
+	if !ok || !slices.Equal(got, want) {
+		t.Fatalf("runExecutionArns = %q, %v; want %q", got, ok, want)
+	}
+
+	// 完整的 executionArn 不再推导候选；扇出状态机与主状态机相同时不重复查找。
+	execArn := want[1]
+	if got, _ := runExecutionArns(testStateMachineArn, execArn); !slices.Equal(got, []string{execArn}) {
+		t.Errorf("runExecutionArns(arn) = %q", got)
+	}
+	t.Setenv("FANOUT_STATE_MACHINE_ARN", testStateMachineArn)
+	if got, _ := runExecutionArns(testStateMachineArn, "run-1"); !slices.Equal(got, want[:1]) {
+		t.Errorf("runExecutionArns with identical fan-out ARN = %q", got)
+	}
+}
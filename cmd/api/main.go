@@ -1,17 +1,39 @@
 // Lambda (API Handler)
 //
 // 作用：作为 API Gateway 的后端处理器，启动 Step Functions 执行并同步等待完成后返回。
+// Standard workflow 通过 StartExecution + 等待完成；Express workflow 通过 StartSyncExecution（见 express.go）。
 // 链路：Client -> API Gateway -> ApiFunction -> Step Functions -> Dispatcher -> SQS -> Worker -> (callback) -> Step Functions -> ApiFunction 返回
 //
-// 环境变量：STATE_MACHINE_ARN（Step Functions State Machine ARN）
+// 路由：
+//   - POST /run：启动执行；默认同步等待完成，async=true 时立即返回 202 + executionArn
+//   - GET /runs/{id}：查询执行状态；id 可以是 executionArn、runId 或执行名称（execution name），runId/名称同时在扇出状态机下查找
+//   - DELETE /runs/{id}：StopExecution 中止执行（可选请求体 error/cause）
+//
+// 环境变量：
+//   - STATE_MACHINE_ARN（Step Functions State Machine ARN）
+//   - WORKFLOW_TYPE（可选，STANDARD|EXPRESS；未配置时通过 DescribeStateMachine 探测）
+//   - EXPRESS_STATE_MACHINE_ARN（可选，请求 workflowType=EXPRESS 时使用的 Express 状态机）
+//   - FANOUT_STATE_MACHINE_ARN（可选，请求 fanout=N 时使用的 Map 扇出状态机，见 fanout.go）
+//   - DISPATCH_TIMEOUT_SECONDS（可选，Dispatch 任务在 SQS 延迟之后的超时预算，默认 28）
+//   - DISPATCH_HEARTBEAT_SECONDS（可选，Dispatch 任务的心跳超时，默认 10）
+//   - WORKER_BATCHING_WINDOW_SECONDS（可选，Worker SQS 事件源的批处理窗口，计入 Dispatch 任务的超时与心跳超时）
+//   - COMPLETION_MODE（可选，poll|ddb，默认 poll）
+//   - TABLE_NAME（ddb 完成通知模式读取 Worker 写入的完成记录）
+//   - COMPLETION_POLL_INITIAL_MS / COMPLETION_POLL_MAX_MS（等待完成的指数退避参数，poll 与 ddb 模式共用）
+//   - COMPLETION_FALLBACK_MS（ddb 模式回退到 DescribeExecution 的间隔）
+//   - S3_ENDPOINT_URL（可选，解引用 outputRef 时使用的 S3 兼容端点，见 claimcheck.go）
+//
 // 对应 SAM 资源：template.yaml 中的 ApiFunction
 package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
@@ -21,16 +43,50 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sfn"
 	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+
+	"testsqs/internal/claimcheck"
+	"testsqs/internal/env"
 )
 
 type apiRequest struct {
 	RunID            string `json:"runId,omitempty"`
 	DelaySeconds     int    `json:"delaySeconds,omitempty"`
 	MessageBodyBytes int    `json:"messageBodyBytes,omitempty"`
+	// 可选：Worker 模拟工作耗时（毫秒）；处理期间 Worker 发送心跳并延长消息可见性。
+	WorkMs int64 `json:"workMs,omitempty"`
+	// 可选：Worker 处理器（sleep/cpu/ddb/echo，默认 sleep）及其参数，透传到 Worker。
+	TaskType   string          `json:"taskType,omitempty"`
+	TaskParams json.RawMessage `json:"taskParams,omitempty"`
+	// 可选：填充内容模式（repeated/random/json，默认 repeated）与消息体压缩方式（none/gzip/zstd，默认 none）。
+	ContentMode string `json:"contentMode,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	// 可选：路由通道（例如 high/low），由 Dispatcher 的 QUEUE_ROUTES 映射到队列；为空时按 taskType 路由或使用默认队列。
+	Priority string `json:"priority,omitempty"`
+	// 可选：Dispatcher 与 Worker 之间的传输方式（sqs/sns/sns-sqs/eventbridge/lambda/kinesis，默认 sqs）；非 sqs 时延迟由状态机的 Wait 状态完成。
+	Transport string `json:"transport,omitempty"`
+	// 可选：发送到 FIFO 队列；messageGroupId 默认取 runId。FIFO 的延迟由状态机的 Wait 状态完成。
+	Fifo           bool   `json:"fifo,omitempty"`
+	MessageGroupID string `json:"messageGroupId,omitempty"`
+	// 可选：扇出分支数（1..40）；大于 0 时使用 Map 扇出状态机并行分发 N 个任务，响应中带各分支耗时（见 fanout.go）。
+	Fanout int `json:"fanout,omitempty"`
 	// 可选：客户端控制最大等待（毫秒），防止 API Gateway 超时。默认 25000ms。
 	MaxWaitMs int `json:"maxWaitMs,omitempty"`
+	// 可选：异步模式。为 true 时启动执行后立即返回 202，之后通过 GET /runs/{id} 查询结果。
+	Async bool `json:"async,omitempty"`
+	// 可选：STANDARD/EXPRESS；EXPRESS 使用 StartSyncExecution（需要配置 EXPRESS_STATE_MACHINE_ARN 或 STATE_MACHINE_ARN 本身是 Express）。
+	WorkflowType string `json:"workflowType,omitempty"`
+	// 可选：同步等待方式，"poll"（DescribeExecution 轮询）或 "ddb"（等待 Worker 写入的完成记录）；默认取 COMPLETION_MODE。
+	CompletionMode string `json:"completionMode,omitempty"`
+}
+
+// stopRequest 是 DELETE /runs/{id} 的可选请求体，透传给 StopExecution。
+type stopRequest struct {
+	Error string `json:"error,omitempty"`
+	Cause string `json:"cause,omitempty"`
 }
 
 type apiResponse struct {
@@ -39,6 +95,21 @@ type apiResponse struct {
 	TotalMs      int64           `json:"totalMs"`
 	Output       json.RawMessage `json:"output,omitempty"`
 	Error        string          `json:"error,omitempty"`
+	// ErrorCode：执行失败时的 Error 字段（例如 Worker 上报的 Worker.InvalidMessage），Error 为对应的 Cause。
+	ErrorCode string `json:"errorCode,omitempty"`
+	// Attached：本次请求没有启动新执行，而是附着到同一 runId 已存在的执行（客户端重试）。
+	Attached bool `json:"attached,omitempty"`
+	// Completion：同步模式下实际判定完成的方式（poll/ddb）。
+	Completion string `json:"completion,omitempty"`
+	// WorkerCompletedUnixNano：ddb 模式下完成记录中的 Worker 完成时间戳（Worker 主机时钟，不参与 TotalMs 计算）。
+	WorkerCompletedUnixNano int64 `json:"workerCompletedUnixNano,omitempty"`
+	// WorkflowType / Billing：Express（StartSyncExecution）时返回，Billing 为 Step Functions 计费信息。
+	WorkflowType string          `json:"workflowType,omitempty"`
+	Billing      *billingDetails `json:"billing,omitempty"`
+	// OutputRef：Output 曾被 Worker 转存到 S3（超过 256KB），Output 已由 API 解引用为完整内容。
+	OutputRef *claimcheck.PayloadRef `json:"outputRef,omitempty"`
+	// Fanout：扇出执行成功时各分支的耗时汇总（Output 为按分支顺序排列的 Worker Output 数组）。
+	Fanout *fanoutSummary `json:"fanout,omitempty"`
 }
 
 var (
@@ -46,6 +117,8 @@ var (
 	initErr  error
 
 	sfnClient *sfn.Client
+	ddbClient *dynamodb.Client
+	s3Client  *s3.Client
 )
 
 func initAWS() {
@@ -56,6 +129,8 @@ func initAWS() {
 			return
 		}
 		sfnClient = sfn.NewFromConfig(cfg)
+		ddbClient = dynamodb.NewFromConfig(cfg)
+		s3Client = claimcheck.NewS3Client(cfg)
 	})
 }
 
@@ -80,6 +155,29 @@ func clampInt(v, minV, maxV int) int {
 	return v
 }
 
+// dispatchTimeouts 计算 Dispatch 任务的超时与心跳超时（秒）。
+// 心跳超时取 DISPATCH_HEARTBEAT_SECONDS（默认 10）加上 SQS 延迟与 Worker 事件源的批处理窗口（WORKER_BATCHING_WINDOW_SECONDS），
+// 且必须小于任务超时。
+func dispatchTimeouts(delaySeconds int, workMs int64) (int, int) {
+	window := env.Int("WORKER_BATCHING_WINDOW_SECONDS", 0)
+	timeout := delaySeconds + window + env.Int("DISPATCH_TIMEOUT_SECONDS", 28) + int((workMs+999)/1000)
+	if timeout < 2 {
+		timeout = 2
+	}
+	// 没有模拟工作时 Worker 不发送心跳：心跳超时等同于关闭（timeout-1）。
+	if workMs <= 0 {
+		return timeout, timeout - 1
+	}
+	heartbeat := env.Int("DISPATCH_HEARTBEAT_SECONDS", 10)
+	// Worker 收到消息之前无法发送心跳：SQS 延迟、批处理窗口内凑批与冷启动期间都没有心跳，心跳超时需要覆盖它们
+	// （DISPATCH_HEARTBEAT_SECONDS 本身留有冷启动余量）。收到之后在批内排队等待的时间由 Worker 的等待心跳覆盖。
+	heartbeat += delaySeconds + window
+	if heartbeat >= timeout {
+		heartbeat = timeout - 1
+	}
+	return timeout, heartbeat
+}
+
 func effectiveTimeout(ctx context.Context, requested time.Duration) time.Duration {
 	// API Gateway 最大 29s，Lambda 本函数 Timeout 30s；默认目标：25s。
 	// 如果 Lambda context 有更早 deadline，优先以 deadline 为准（并留一点余量）。
@@ -115,6 +213,17 @@ func handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.API
 		return jsonResp(500, apiResponse{Status: "ERROR", Error: "missing env STATE_MACHINE_ARN"})
 	}
 
+	switch {
+	case req.HTTPMethod == "GET" && req.Resource == "/runs/{id}":
+		return handleGetRun(ctx, smArn, req.PathParameters["id"])
+	case req.HTTPMethod == "DELETE" && req.Resource == "/runs/{id}":
+		return handleStopRun(ctx, smArn, req.PathParameters["id"], req)
+	default:
+		return handleRun(ctx, smArn, req)
+	}
+}
+
+func handleRun(ctx context.Context, smArn string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	var body apiRequest
 	if strings.TrimSpace(req.Body) != "" {
 		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
@@ -129,6 +238,35 @@ func handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.API
 	if body.MessageBodyBytes < 0 {
 		body.MessageBodyBytes = 0
 	}
+	if body.WorkMs < 0 {
+		body.WorkMs = 0
+	}
+	body.ContentMode = strings.ToLower(strings.TrimSpace(body.ContentMode))
+	switch body.ContentMode {
+	case "", "repeated", "random", "json":
+	default:
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: fmt.Sprintf("invalid contentMode %q (want repeated, random or json)", body.ContentMode)})
+	}
+	body.Compression = strings.ToLower(strings.TrimSpace(body.Compression))
+	switch body.Compression {
+	case "", "none", "gzip", "zstd":
+	default:
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: fmt.Sprintf("invalid compression %q (want none, gzip or zstd)", body.Compression)})
+	}
+	body.Transport = strings.ToLower(strings.TrimSpace(body.Transport))
+	switch body.Transport {
+	case "":
+		body.Transport = "sqs"
+	case "sqs", "sns", "sns-sqs", "eventbridge", "lambda", "kinesis":
+	default:
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: fmt.Sprintf("invalid transport %q (want sqs, sns, sns-sqs, eventbridge, lambda or kinesis)", body.Transport)})
+	}
+	if body.Fanout < 0 {
+		body.Fanout = 0
+	}
+	if body.Fanout > maxFanout {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: fmt.Sprintf("invalid fanout %d (max %d)", body.Fanout, maxFanout)})
+	}
 
 	maxWait := 25 * time.Second
 	if body.MaxWaitMs > 0 {
@@ -142,63 +280,360 @@ func handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.API
 	callCtx, cancel := context.WithTimeout(ctx, maxWait)
 	defer cancel()
 
-	inputBytes, _ := json.Marshal(map[string]any{
-		"runId":            body.RunID,
-		"delaySeconds":     body.DelaySeconds,
-		"messageBodyBytes": body.MessageBodyBytes,
-	})
+	// dispatchTimeoutSeconds / dispatchHeartbeatSeconds：状态机 Dispatch 任务的 TimeoutSecondsPath / HeartbeatSecondsPath。
+	// 超时包含 SQS 延迟与模拟工作时长本身，保证 async 模式下长延迟（最多 900s）或长任务的执行不会被提前判定超时；
+	// 长任务由 Worker 的心跳维持，心跳中断才会以 States.HeartbeatTimeout 失败。
+	timeoutSeconds, heartbeatSeconds := dispatchTimeouts(body.DelaySeconds, body.WorkMs)
+	input := map[string]any{
+		"runId":                    body.RunID,
+		"delaySeconds":             body.DelaySeconds,
+		"messageBodyBytes":         body.MessageBodyBytes,
+		"workMs":                   body.WorkMs,
+		"taskType":                 body.TaskType,
+		"fifo":                     body.Fifo,
+		"transport":                body.Transport,
+		"dispatchTimeoutSeconds":   timeoutSeconds,
+		"dispatchHeartbeatSeconds": heartbeatSeconds,
+	}
+	if len(body.TaskParams) > 0 {
+		input["taskParams"] = body.TaskParams
+	}
+	if body.ContentMode != "" {
+		input["contentMode"] = body.ContentMode
+	}
+	if body.Compression != "" {
+		input["compression"] = body.Compression
+	}
+	if body.MessageGroupID != "" {
+		input["messageGroupId"] = body.MessageGroupID
+	}
+	if body.Priority != "" {
+		input["priority"] = body.Priority
+	}
+	if body.Fanout > 0 {
+		input["fanout"] = body.Fanout
+		input["branches"] = fanoutBranches(body.Fanout)
+	}
+
+	// 执行名称由 runId 确定性推导：客户端超时重试时不会启动重复执行。
+	// 同名同输入且仍在运行时 StartExecution 本身是幂等的；已结束或输入不同则返回 ExecutionAlreadyExists，此时附着到已有执行。
+	execName := executionNameFromRunID(body.RunID)
+	attached := false
+
+	var runArn, wfType string
+	var err error
+	if body.Fanout > 0 {
+		runArn, wfType, err = fanoutStateMachine(body.WorkflowType)
+	} else {
+		runArn, wfType, err = resolveStateMachine(callCtx, smArn, body.WorkflowType)
+	}
+	if err != nil {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error()})
+	}
+	if wfType == workflowTypeExpress {
+		if body.Async {
+			return jsonResp(400, apiResponse{Status: "ERROR", Error: "async is not supported for EXPRESS workflows", WorkflowType: wfType})
+		}
+		// Express 以 StartSyncExecution 同步执行：延迟与模拟工作必须在本次请求的等待时间内完成，
+		// Dispatch 任务（以及 Dispatcher 等待完成记录）的超时也以等待时间为上限，而不是 Standard 的 dispatchTimeouts。
+		expressTimeout, err := expressDispatchTimeout(body.DelaySeconds, body.WorkMs, maxWait)
+		if err != nil {
+			return jsonResp(400, apiResponse{Status: "ERROR", Error: err.Error(), WorkflowType: wfType})
+		}
+		input["dispatchTimeoutSeconds"] = expressTimeout
+		delete(input, "dispatchHeartbeatSeconds")
+		inputBytes, _ := json.Marshal(input)
+		code, resp := runExpress(callCtx, runArn, execName, inputBytes)
+		return jsonResp(code, resp)
+	}
+	inputBytes, _ := json.Marshal(input)
 
 	start := time.Now()
+	var execArn string
 	startOut, err := sfnClient.StartExecution(callCtx, &sfn.StartExecutionInput{
-		StateMachineArn: aws.String(smArn),
+		StateMachineArn: aws.String(runArn),
+		Name:            aws.String(execName),
 		Input:           aws.String(string(inputBytes)),
 	})
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		var exists *sfntypes.ExecutionAlreadyExists
+		switch {
+		case errors.As(err, &exists):
+			attached = true
+			execArn = executionArnFromID(runArn, execName)
+		case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
 			return jsonResp(504, apiResponse{Status: "TIMEOUT", Error: err.Error()})
+		default:
+			return jsonResp(502, apiResponse{Status: "ERROR", Error: fmt.Sprintf("start execution: %v", err)})
 		}
-		return jsonResp(502, apiResponse{Status: "ERROR", Error: fmt.Sprintf("start execution: %v", err)})
+	} else {
+		execArn = aws.ToString(startOut.ExecutionArn)
 	}
-
-	execArn := aws.ToString(startOut.ExecutionArn)
 	if execArn == "" {
 		return jsonResp(502, apiResponse{Status: "ERROR", Error: "missing executionArn"})
 	}
 
-	// Standard workflow 没有 StartSyncExecution：通过 DescribeExecution 轮询等待完成。
-	// 注意：轮询间隔要小心，避免频繁打 API；这里用轻量退避。
-	interval := 50 * time.Millisecond
+	// 附着 + 异步：直接返回已有执行的当前状态。
+	if attached && body.Async {
+		code, resp := describeRun(callCtx, execArn)
+		resp.Attached = true
+		return jsonResp(code, resp)
+	}
+
+	// 异步模式：不等待完成，客户端随后通过 GET /runs/{id} 查询。
+	if body.Async {
+		elapsed := time.Since(start).Milliseconds()
+		return jsonResp(202, apiResponse{ExecutionArn: execArn, TotalMs: elapsed, Status: string(sfntypes.ExecutionStatusRunning)})
+	}
+
+	// 完成通知模式：等待 Worker 写入的完成记录（DynamoDB），并定期回退到 DescribeExecution。
+	// 附着到已有执行时记录可能早已存在，其时间戳不属于本次请求，仍走轮询。
+	if !attached && body.Fanout == 0 && completionMode(body.CompletionMode) == completionModeDdb {
+		code, resp := waitForCompletionRecord(callCtx, body.RunID, execArn, start)
+		return jsonResp(code, resp)
+	}
+
+	code, resp := pollExecution(callCtx, execArn, start, attached)
+	return jsonResp(code, resp)
+}
+
+// pollExecution 通过 DescribeExecution 轮询等待执行结束（Standard workflow 没有 StartSyncExecution）。
+// 轮询间隔按 pollBackoff 指数退避：短执行很快返回，长执行不会持续以高频消耗 DescribeExecution 配额。
+func pollExecution(ctx context.Context, execArn string, start time.Time, attached bool) (int, apiResponse) {
+	interval, maxInterval := pollBackoff()
 	for {
-		if callCtx.Err() != nil {
+		if ctx.Err() != nil {
 			elapsed := time.Since(start).Milliseconds()
-			return jsonResp(504, apiResponse{ExecutionArn: execArn, TotalMs: elapsed, Status: "TIMEOUT", Error: callCtx.Err().Error()})
+			return 504, apiResponse{ExecutionArn: execArn, TotalMs: elapsed, Status: "TIMEOUT", Error: ctx.Err().Error(), Attached: attached}
 		}
-		desc, err := sfnClient.DescribeExecution(callCtx, &sfn.DescribeExecutionInput{ExecutionArn: aws.String(execArn)})
+		desc, err := sfnClient.DescribeExecution(ctx, &sfn.DescribeExecutionInput{ExecutionArn: aws.String(execArn)})
 		if err != nil {
 			elapsed := time.Since(start).Milliseconds()
-			return jsonResp(502, apiResponse{ExecutionArn: execArn, TotalMs: elapsed, Status: "ERROR", Error: fmt.Sprintf("describe execution: %v", err)})
+			return 502, apiResponse{ExecutionArn: execArn, TotalMs: elapsed, Status: "ERROR", Error: fmt.Sprintf("describe execution: %v", err)}
 		}
 
-		s := desc.Status
-		if s == sfntypes.ExecutionStatusSucceeded {
-			elapsed := time.Since(start).Milliseconds()
-			var out json.RawMessage
-			if desc.Output != nil {
-				out = json.RawMessage([]byte(aws.ToString(desc.Output)))
+		if isTerminalStatus(desc.Status) {
+			resp := executionResponse(desc)
+			resp.TotalMs = time.Since(start).Milliseconds()
+			// 附着到已有执行时，本次请求的等待时间不代表链路耗时：改用 Step Functions 记录的起止时间。
+			if attached && desc.StartDate != nil && desc.StopDate != nil {
+				resp.TotalMs = desc.StopDate.Sub(*desc.StartDate).Milliseconds()
 			}
-			return jsonResp(200, apiResponse{ExecutionArn: execArn, TotalMs: elapsed, Status: string(s), Output: out})
-		}
-		if s == sfntypes.ExecutionStatusFailed || s == sfntypes.ExecutionStatusAborted || s == sfntypes.ExecutionStatusTimedOut {
-			elapsed := time.Since(start).Milliseconds()
-			msg := aws.ToString(desc.Cause)
-			if msg == "" {
-				msg = aws.ToString(desc.Error)
-			}
-			return jsonResp(500, apiResponse{ExecutionArn: execArn, TotalMs: elapsed, Status: string(s), Error: msg})
+			resp.Attached = attached
+			resp.Completion = completionModePoll
+			return statusCodeFor(desc.Status), resolveFanout(ctx, resolveOutputRef(ctx, resp), desc.StartDate)
 		}
 
 		time.Sleep(interval)
+		interval = min(interval*2, maxInterval)
+	}
+}
+
+// handleGetRun 查询任意一次执行的当前状态，返回与 POST /run 相同的 apiResponse 结构。
+// 查询本身成功即返回 200（包括 RUNNING/FAILED 等状态），执行不存在返回 404。
+func handleGetRun(ctx context.Context, smArn, id string) (events.APIGatewayProxyResponse, error) {
+	candidates, ok := runExecutionArns(smArn, id)
+	if !ok {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: "missing run id"})
+	}
+	_, code, resp := lookupRun(ctx, candidates)
+	return jsonResp(code, resp)
+}
+
+// lookupRun 依次查询候选 executionArn，返回第一个存在的执行（以及 describeRun 的结果）；都不存在时返回最后一个 404。
+func lookupRun(ctx context.Context, candidates []string) (string, int, apiResponse) {
+	var code int
+	var resp apiResponse
+	for _, execArn := range candidates {
+		code, resp = describeRun(ctx, execArn)
+		if code != 404 {
+			return execArn, code, resp
+		}
+	}
+	return "", code, resp
+}
+
+// handleStopRun 通过 StopExecution 中止执行；请求体可选 {"error": "...", "cause": "..."}。
+// 中止成功返回 200 + status=ABORTED；执行已经处于其它终态时返回 409 与实际状态。
+func handleStopRun(ctx context.Context, smArn, id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	candidates, ok := runExecutionArns(smArn, id)
+	if !ok {
+		return jsonResp(400, apiResponse{Status: "ERROR", Error: "missing run id"})
+	}
+
+	var body stopRequest
+	if strings.TrimSpace(req.Body) != "" {
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			return jsonResp(400, apiResponse{Status: "ERROR", Error: fmt.Sprintf("invalid json body: %v", err)})
+		}
+	}
+
+	// 执行名称可能属于任一候选状态机：先找到实际存在的执行。
+	execArn := candidates[0]
+	if len(candidates) > 1 {
+		found, code, resp := lookupRun(ctx, candidates)
+		if found == "" {
+			return jsonResp(code, resp)
+		}
+		execArn = found
+	}
+	if strings.TrimSpace(body.Error) == "" {
+		body.Error = "Api.Aborted"
+	}
+	if strings.TrimSpace(body.Cause) == "" {
+		body.Cause = "stopped via DELETE /runs/{id}"
+	}
+
+	_, err := sfnClient.StopExecution(ctx, &sfn.StopExecutionInput{
+		ExecutionArn: aws.String(execArn),
+		Error:        aws.String(body.Error),
+		Cause:        aws.String(body.Cause),
+	})
+	if err != nil {
+		if code, ok := describeErrorCode(err); ok {
+			return jsonResp(code, apiResponse{ExecutionArn: execArn, Status: errorStatusFor(code), Error: err.Error()})
+		}
+		return jsonResp(502, apiResponse{ExecutionArn: execArn, Status: "ERROR", Error: fmt.Sprintf("stop execution: %v", err)})
+	}
+
+	// StopExecution 对已结束的执行不会报错：再查询一次，以实际状态为准。
+	code, resp := describeRun(ctx, execArn)
+	if code == 200 && resp.Status != string(sfntypes.ExecutionStatusAborted) {
+		code = 409
+	}
+	return jsonResp(code, resp)
+}
+
+// describeRun 查询执行并返回 HTTP 状态码与 apiResponse；TotalMs 以 Step Functions 记录的 StartDate/StopDate 计算。
+func describeRun(ctx context.Context, execArn string) (int, apiResponse) {
+	desc, err := sfnClient.DescribeExecution(ctx, &sfn.DescribeExecutionInput{ExecutionArn: aws.String(execArn)})
+	if err != nil {
+		if code, ok := describeErrorCode(err); ok {
+			return code, apiResponse{ExecutionArn: execArn, Status: errorStatusFor(code), Error: err.Error()}
+		}
+		return 502, apiResponse{ExecutionArn: execArn, Status: "ERROR", Error: fmt.Sprintf("describe execution: %v", err)}
+	}
+
+	resp := executionResponse(desc)
+	// 仍在运行时以当前时间为准。
+	if desc.StartDate != nil {
+		end := time.Now()
+		if desc.StopDate != nil {
+			end = *desc.StopDate
+		}
+		resp.TotalMs = end.Sub(*desc.StartDate).Milliseconds()
+	}
+	return 200, resolveFanout(ctx, resolveOutputRef(ctx, resp), desc.StartDate)
+}
+
+// describeErrorCode 把“客户端错误”类的 Step Functions 异常映射为 HTTP 状态码。
+func describeErrorCode(err error) (int, bool) {
+	var notFound *sfntypes.ExecutionDoesNotExist
+	if errors.As(err, &notFound) {
+		return 404, true
+	}
+	var invalidArn *sfntypes.InvalidArn
+	if errors.As(err, &invalidArn) {
+		return 400, true
+	}
+	return 0, false
+}
+
+func errorStatusFor(code int) string {
+	if code == 404 {
+		return "NOT_FOUND"
+	}
+	return "ERROR"
+}
+
+// runExecutionArns 解析路径参数 {id}（可能被 URL 编码）并转换为候选 executionArn：
+// executionArn 原样返回；runId（或执行名称）依次在 STATE_MACHINE_ARN 与 FANOUT_STATE_MACHINE_ARN（扇出执行）下推导。
+// Express 执行不能通过 DescribeExecution/StopExecution 查询或中止，不在候选之列。
+func runExecutionArns(smArn, id string) ([]string, bool) {
+	id = strings.TrimSpace(id)
+	if decoded, err := url.PathUnescape(id); err == nil {
+		id = decoded
+	}
+	if id == "" {
+		return nil, false
+	}
+	if strings.HasPrefix(id, "arn:") {
+		return []string{id}, true
+	}
+	// runId 与执行名称的映射与 POST /run 相同：合法的执行名称原样使用，否则取 "run-" + sha256 前缀。
+	name := executionNameFromRunID(id)
+	candidates := []string{executionArnFromID(smArn, name)}
+	if fanoutArn := strings.TrimSpace(os.Getenv("FANOUT_STATE_MACHINE_ARN")); fanoutArn != "" && fanoutArn != smArn {
+		candidates = append(candidates, executionArnFromID(fanoutArn, name))
+	}
+	return candidates, true
+}
+
+// executionResponse 把 DescribeExecution 的结果映射为 apiResponse（不含 TotalMs）。
+func executionResponse(desc *sfn.DescribeExecutionOutput) apiResponse {
+	resp := apiResponse{ExecutionArn: aws.ToString(desc.ExecutionArn), Status: string(desc.Status)}
+	switch desc.Status {
+	case sfntypes.ExecutionStatusSucceeded:
+		if desc.Output != nil {
+			resp.Output = json.RawMessage([]byte(aws.ToString(desc.Output)))
+		}
+	case sfntypes.ExecutionStatusFailed, sfntypes.ExecutionStatusAborted, sfntypes.ExecutionStatusTimedOut:
+		msg := aws.ToString(desc.Cause)
+		if msg == "" {
+			msg = aws.ToString(desc.Error)
+		}
+		resp.Error = msg
+		resp.ErrorCode = aws.ToString(desc.Error)
+	}
+	return resp
+}
+
+func isTerminalStatus(s sfntypes.ExecutionStatus) bool {
+	switch s {
+	case sfntypes.ExecutionStatusSucceeded, sfntypes.ExecutionStatusFailed, sfntypes.ExecutionStatusAborted, sfntypes.ExecutionStatusTimedOut:
+		return true
+	}
+	return false
+}
+
+// statusCodeFor 同步等待模式下的 HTTP 状态码：成功 200，其余终态 500。
+func statusCodeFor(s sfntypes.ExecutionStatus) int {
+	if s == sfntypes.ExecutionStatusSucceeded {
+		return 200
+	}
+	return 500
+}
+
+// executionNameFromRunID 由 runId 确定性推导执行名称。
+// 执行名称限制 1..80 个字符且不能包含空白与 `"#%\^|~$&,;:/` 等特殊字符；
+// runId 本身合法时直接使用，否则使用 "run-" + sha256 前缀。
+func executionNameFromRunID(runID string) string {
+	if len(runID) <= 80 && validExecutionName(runID) {
+		return runID
+	}
+	sum := sha256.Sum256([]byte(runID))
+	return "run-" + hex.EncodeToString(sum[:16])
+}
+
+func validExecutionName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// executionArnFromID 支持直接传 executionArn，或传执行名称并由 state machine ARN 推导：
+// arn:aws:states:region:account:stateMachine:Name -> arn:aws:states:region:account:execution:Name:executionName
+func executionArnFromID(smArn, id string) string {
+	if strings.HasPrefix(id, "arn:") {
+		return id
 	}
+	return strings.Replace(smArn, ":stateMachine:", ":execution:", 1) + ":" + id
 }
 
 func main() {
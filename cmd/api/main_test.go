@@ -0,0 +1,105 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testStateMachineArn = "arn:aws:states:us-east-1:123456789012:stateMachine:TestStateMachine"
+
+func TestRunExecutionArns(t *testing.T) {
+	t.Setenv("FANOUT_STATE_MACHINE_ARN", "")
+	execArn := "arn:aws:states:us-east-1:123456789012:execution:TestStateMachine:run-1"
+	cases := []struct {
+		name   string
+		id     string
+		want   []string
+		wantOK bool
+	}{
+		{name: "execution arn", id: execArn, want: []string{execArn}, wantOK: true},
+		{name: "url encoded arn", id: "arn%3Aaws%3Astates%3Aus-east-1%3A123456789012%3Aexecution%3ATestStateMachine%3Arun-1", want: []string{execArn}, wantOK: true},
+		{name: "execution name", id: "run-1", want: []string{execArn}, wantOK: true},
+		{name: "trimmed", id: "  run-1 ", want: []string{execArn}, wantOK: true},
+		// 不是合法执行名称的 runId 与 POST /run 一样映射为 "run-" + sha256 前缀。
+		{name: "run id", id: "order:42", want: []string{executionArnFromID(testStateMachineArn, executionNameFromRunID("order:42"))}, wantOK: true},
+		{name: "empty", id: "", wantOK: false},
+		{name: "blank", id: "   ", wantOK: false},
+	}
+	for _, tc := range cases {
+		got, ok := runExecutionArns(testStateMachineArn, tc.id)
+		if ok != tc.wantOK || !slices.Equal(got, tc.want) {
+			t.Errorf("runExecutionArns(%s) = %q, %v; want %q, %v", tc.name, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestExecutionNameFromRunID(t *testing.T) {
+	long := strings.Repeat("a", 81)
+	cases := []struct {
+		runID  string
+		hashed bool
+	}{
+		{runID: "run-1", hashed: false},
+		{runID: "Run_2.retry-3", hashed: false},
+		{runID: strings.Repeat("a", 80), hashed: false},
+		{runID: long, hashed: true},
+		{runID: "", hashed: true},
+		{runID: "order:42", hashed: true},
+		{runID: "has space", hashed: true},
+		{runID: "a/b", hashed: true},
+		{runID: "订单-1", hashed: true},
+	}
+	for _, tc := range cases {
+		got := executionNameFromRunID(tc.runID)
+		if !tc.hashed {
+			if got != tc.runID {
+				t.Errorf("executionNameFromRunID(%q) = %q, want unchanged", tc.runID, got)
+			}
+			continue
+		}
+		// "run-" + 16 字节 sha256 的十六进制：36 个字符，本身是合法执行名称，且同一 runId 结果稳定。
+		if !strings.HasPrefix(got, "run-") || len(got) != 36 || !validExecutionName(got) {
+			t.Errorf("executionNameFromRunID(%q) = %q, want run-<32 hex>", tc.runID, got)
+		}
+		if again := executionNameFromRunID(tc.runID); again != got {
+			t.Errorf("executionNameFromRunID(%q) not deterministic: %q != %q", tc.runID, got, again)
+		}
+	}
+	if executionNameFromRunID("order:42") == executionNameFromRunID("order:43") {
+		t.Errorf("different runIds map to the same execution name")
+	}
+}
+
+func TestDispatchTimeouts(t *testing.T) {
+	cases := []struct {
+		name          string
+		env           map[string]string
+		delaySeconds  int
+		workMs        int64
+		wantTimeout   int
+		wantHeartbeat int
+	}{
+		// 没有模拟工作时 Worker 不发送心跳：心跳超时为 timeout-1。
+		{name: "no work", wantTimeout: 28, wantHeartbeat: 27},
+		{name: "work rounds up", workMs: 1500, wantTimeout: 30, wantHeartbeat: 10},
+		{name: "delay", delaySeconds: 5, workMs: 2000, wantTimeout: 35, wantHeartbeat: 15},
+		{name: "batching window", env: map[string]string{"WORKER_BATCHING_WINDOW_SECONDS": "3"}, delaySeconds: 2, workMs: 1000, wantTimeout: 34, wantHeartbeat: 15},
+		{name: "heartbeat capped below timeout", env: map[string]string{"DISPATCH_TIMEOUT_SECONDS": "1"}, workMs: 1, wantTimeout: 2, wantHeartbeat: 1},
+		{name: "custom heartbeat", env: map[string]string{"DISPATCH_HEARTBEAT_SECONDS": "4"}, workMs: 60000, wantTimeout: 88, wantHeartbeat: 4},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, k := range []string{"DISPATCH_TIMEOUT_SECONDS", "DISPATCH_HEARTBEAT_SECONDS", "WORKER_BATCHING_WINDOW_SECONDS"} {
+				t.Setenv(k, tc.env[k])
+			}
+			timeout, heartbeat := dispatchTimeouts(tc.delaySeconds, tc.workMs)
+			if timeout != tc.wantTimeout || heartbeat != tc.wantHeartbeat {
+				t.Fatalf("dispatchTimeouts(%d, %d) = %d, %d; want %d, %d", tc.delaySeconds, tc.workMs, timeout, heartbeat, tc.wantTimeout, tc.wantHeartbeat)
+			}
+			if heartbeat >= timeout {
+				t.Fatalf("heartbeat %d must be below timeout %d", heartbeat, timeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+// 大消息 claim-check：SQS 消息体上限 256KB（262144 字节）。序列化后的消息体超过 PAYLOAD_OFFLOAD_THRESHOLD_BYTES
+// （默认 256000，给 SQS 的其它开销留余量）时，完整消息体写入 PAYLOAD_BUCKET，队列中只放一个带 payloadRef 的指针消息，
+// Worker 收到后透明地从 S3 取回（见 cmd/worker/claimcheck.go；指针与读写见 internal/claimcheck）。
+// S3_ENDPOINT_URL 可指向本地 S3 兼容服务（MinIO、LocalStack 等，使用 path-style 寻址），便于在本地做超过 256KB 的消息大小扫描。
+
+import (
+	"context"
+	"fmt"
+
+	"testsqs/internal/claimcheck"
+)
+
+// offloadPayload 把 body（编码方式为 encoding）写入 PAYLOAD_BUCKET 的 <prefix>/<id>.json 并返回指针。
+func offloadPayload(ctx context.Context, prefix, id string, body []byte, encoding string) (*claimcheck.PayloadRef, error) {
+	ref, err := claimcheck.Offload(ctx, s3Client, fmt.Sprintf("%s/%s.json", prefix, id), body, encoding)
+	if err != nil {
+		return nil, fmt.Errorf("offload payload: %w", err)
+	}
+	return ref, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+// 消息体内容与压缩：
+//   - contentMode 决定 messageBodyBytes 填充的内容：repeated（默认，重复的 'x'）、random（随机字母数字，几乎不可压缩）、
+//     json（类 JSON 的记录序列，接近真实业务负载）；
+//   - compression 为 gzip/zstd 时，整个消息体 JSON 压缩后做 base64 编码再发送，
+//     通过消息属性 contentEncoding（gzip+base64 / zstd+base64）标记，Worker 据此解码（见 cmd/worker/codec.go）。
+// 消息属性 rawBytes / encodeNanos 记录压缩前大小与编码耗时，由 Worker 写入 callback Output。
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+
+	contentModeRepeated = "repeated"
+	contentModeRandom   = "random"
+	contentModeJSON     = "json"
+)
+
+// zstd 编码器可并发复用（EncodeAll），热启动时不必重复创建。
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// normalizeCompression 校验并规范化 compression，空值为 none。
+func normalizeCompression(v string) (string, error) {
+	switch c := strings.ToLower(strings.TrimSpace(v)); c {
+	case "", compressionNone:
+		return compressionNone, nil
+	case compressionGzip, compressionZstd:
+		return c, nil
+	default:
+		return "", fmt.Errorf("invalid compression %q (want none, gzip or zstd)", v)
+	}
+}
+
+// normalizeContentMode 校验并规范化 contentMode，空值为 repeated。
+func normalizeContentMode(v string) (string, error) {
+	switch m := strings.ToLower(strings.TrimSpace(v)); m {
+	case "", contentModeRepeated:
+		return contentModeRepeated, nil
+	case contentModeRandom, contentModeJSON:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid contentMode %q (want repeated, random or json)", v)
+	}
+}
+
+// encodedBody 是实际发送的消息体及其元数据。
+type encodedBody struct {
+	Body        []byte
+	Encoding    string // 为空表示未编码
+	RawBytes    int
+	EncodeNanos int64
+}
+
+// encodeBody 按 compression 压缩并 base64 编码 raw；none 时原样返回。
+func encodeBody(raw []byte, compression string) (encodedBody, error) {
+	if compression == compressionNone {
+		return encodedBody{Body: raw, RawBytes: len(raw)}, nil
+	}
+	start := time.Now()
+	var compressed []byte
+	switch compression {
+	case compressionGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(raw); err != nil {
+			return encodedBody{}, fmt.Errorf("gzip: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return encodedBody{}, fmt.Errorf("gzip: %w", err)
+		}
+		compressed = buf.Bytes()
+	case compressionZstd:
+		compressed = zstdEncoder.EncodeAll(raw, nil)
+	default:
+		return encodedBody{}, fmt.Errorf("unsupported compression %q", compression)
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(compressed)))
+	base64.StdEncoding.Encode(out, compressed)
+	return encodedBody{
+		Body:        out,
+		Encoding:    compression + "+base64",
+		RawBytes:    len(raw),
+		EncodeNanos: time.Since(start).Nanoseconds(),
+	}, nil
+}
+
+// attributes 返回描述编码方式与原始大小的消息属性（由 transport 转换为 SQS/SNS 的消息属性）。
+func (e encodedBody) attributes() map[string]string {
+	attrs := map[string]string{"rawBytes": strconv.Itoa(e.RawBytes)}
+	if e.Encoding != "" {
+		attrs["contentEncoding"] = e.Encoding
+		attrs["encodeNanos"] = strconv.FormatInt(e.EncodeNanos, 10)
+	}
+	return attrs
+}
+
+const randomAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// makePadding 生成 extraBytes 字节的填充内容。
+func makePadding(extraBytes int, mode string) string {
+	if extraBytes <= 0 {
+		return ""
+	}
+	pad := make([]byte, 0, extraBytes+64)
+	switch mode {
+	case contentModeRandom:
+		for len(pad) < extraBytes {
+			pad = append(pad, randomAlphabet[rand.IntN(len(randomAlphabet))])
+		}
+	case contentModeJSON:
+		// 字段名重复、取值变化的记录序列：压缩率介于 repeated 与 random 之间。
+		// 注意 padding 是 JSON 字符串，其中的引号在消息体中会被转义。
+		statuses := []string{"pending", "active", "suspended", "closed"}
+		for i := 0; len(pad) < extraBytes; i++ {
+			pad = fmt.Appendf(pad, `{"id":%d,"sku":"SKU-%06d","status":"%s","qty":%d,"price":%.2f,"tags":["t%d","t%d"]},`,
+				i, rand.IntN(1000000), statuses[rand.IntN(len(statuses))], rand.IntN(100), rand.Float64()*1000, rand.IntN(50), rand.IntN(50))
+		}
+	default:
+		for len(pad) < extraBytes {
+			pad = append(pad, 'x')
+		}
+	}
+	return string(pad[:extraBytes])
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decodeForTest 按 Worker 的规则（cmd/worker/codec.go）解码，验证两端约定的编码格式。
+func decodeForTest(t *testing.T, e encodedBody) []byte {
+	t.Helper()
+	if e.Encoding == "" {
+		return e.Body
+	}
+	compressed, err := base64.StdEncoding.DecodeString(string(e.Body))
+	if err != nil {
+		t.Fatalf("base64: %v", err)
+	}
+	switch e.Encoding {
+	case "gzip+base64":
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("gzip: %v", err)
+		}
+		raw, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("gzip: %v", err)
+		}
+		return raw
+	case "zstd+base64":
+		zr, err := zstd.NewReader(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		raw, err := zr.DecodeAll(compressed, nil)
+		if err != nil {
+			t.Fatalf("zstd: %v", err)
+		}
+		return raw
+	}
+	t.Fatalf("unexpected encoding %q", e.Encoding)
+	return nil
+}
+
+func TestEncodeBodyRoundTrip(t *testing.T) {
+	raw := []byte(`{"id":"m-1","padding":"` + makePadding(20000, contentModeJSON) + `"}`)
+	cases := []struct {
+		compression  string
+		wantEncoding string
+	}{
+		{compression: compressionNone},
+		{compression: compressionGzip, wantEncoding: "gzip+base64"},
+		{compression: compressionZstd, wantEncoding: "zstd+base64"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.compression, func(t *testing.T) {
+			e, err := encodeBody(raw, tc.compression)
+			if err != nil {
+				t.Fatalf("encodeBody: %v", err)
+			}
+			if e.Encoding != tc.wantEncoding || e.RawBytes != len(raw) {
+				t.Fatalf("encoding=%q rawBytes=%d, want %q %d", e.Encoding, e.RawBytes, tc.wantEncoding, len(raw))
+			}
+			if got := decodeForTest(t, e); !bytes.Equal(got, raw) {
+				t.Fatalf("round trip returned %d bytes, want %d", len(got), len(raw))
+			}
+
+			attrs := e.attributes()
+			if attrs["rawBytes"] != strconv.Itoa(len(raw)) || attrs["contentEncoding"] != tc.wantEncoding {
+				t.Fatalf("attributes = %v", attrs)
+			}
+			if _, ok := attrs["encodeNanos"]; ok != (tc.wantEncoding != "") {
+				t.Fatalf("encodeNanos present=%v for compression %s", ok, tc.compression)
+			}
+		})
+	}
+
+	if _, err := encodeBody(raw, "brotli"); err == nil {
+		t.Fatalf("encodeBody(brotli) succeeded")
+	}
+}
+
+func TestNormalizeCompressionAndContentMode(t *testing.T) {
+	for in, want := range map[string]string{"": compressionNone, "none": compressionNone, " GZIP ": compressionGzip, "zstd": compressionZstd} {
+		if got, err := normalizeCompression(in); err != nil || got != want {
+			t.Errorf("normalizeCompression(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := normalizeCompression("lz4"); err == nil {
+		t.Errorf("normalizeCompression(lz4) succeeded")
+	}
+	for in, want := range map[string]string{"": contentModeRepeated, "Random": contentModeRandom, "json": contentModeJSON} {
+		if got, err := normalizeContentMode(in); err != nil || got != want {
+			t.Errorf("normalizeContentMode(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := normalizeContentMode("binary"); err == nil {
+		t.Errorf("normalizeContentMode(binary) succeeded")
+	}
+}
+
+func TestMakePadding(t *testing.T) {
+	for _, mode := range []string{contentModeRepeated, contentModeRandom, contentModeJSON} {
+		for _, n := range []int{-1, 0, 1, 63, 4096} {
+			got := makePadding(n, mode)
+			if want := max(n, 0); len(got) != want {
+				t.Errorf("makePadding(%d, %s) has %d bytes, want %d", n, mode, len(got), want)
+			}
+		}
+	}
+	if got := makePadding(100, contentModeRepeated); got != strings.Repeat("x", 100) {
+		t.Errorf("repeated padding = %q", got)
+	}
+	if got := makePadding(1000, contentModeRandom); strings.Trim(got, randomAlphabet) != "" {
+		t.Errorf("random padding contains characters outside the alphabet: %q", strings.Trim(got, randomAlphabet))
+	}
+	if got := makePadding(1000, contentModeJSON); !strings.HasPrefix(got, `{"id":0,"sku":"SKU-`) {
+		t.Errorf("json padding = %.40q...", got)
+	}
+
+	// 压缩率：repeated 远好于 json，json 好于 random（contentMode 用于评估压缩是否划算）。
+	size := func(mode string) int {
+		e, err := encodeBody([]byte(makePadding(64*1024, mode)), compressionGzip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(e.Body)
+	}
+	repeated, js, random := size(contentModeRepeated), size(contentModeJSON), size(contentModeRandom)
+	if !(repeated < js && js < random) {
+		t.Errorf("gzip sizes repeated=%d json=%d random=%d, want increasing", repeated, js, random)
+	}
+}
@@ -1,11 +1,21 @@
 // Lambda #1 (Dispatcher)
 //
-// 作用：向 SQS 队列发送消息。
+// 作用：向 SQS 队列（或请求 transport 指定的其它传输方式，见 transport.go）发送消息。
 // 触发方式：由测试用例或外部调用通过 aws lambda invoke 远程触发。
 // 输入/输出：返回每条消息的发送时间戳与队列名，供测试用例计算端到端延迟。
 //
+// Express workflow 不支持 waitForTaskToken：此时请求中 waitForCompletion=true 且没有 taskToken，
+// Dispatcher 发送消息后等待 Worker 写入的 DynamoDB 完成记录，并把其中的 callback Output 作为自身返回值。
+//
 // 对应 SAM 资源：template.yaml 中的 DispatcherFunction
-// 环境变量：QUEUE_URL（SQS QueueUrl）
+// 环境变量：REQUEST_QUEUE_URL（默认 SQS QueueUrl）、QUEUE_ROUTES（可选，按 priority/taskType 路由到不同队列，见 routing.go）、TABLE_NAME（创建 pending 任务条目；waitForCompletion 模式读取完成记录）、
+// PAYLOAD_BUCKET / PAYLOAD_OFFLOAD_THRESHOLD_BYTES / S3_ENDPOINT_URL（大消息 claim-check，见 claimcheck.go）、
+// FIFO_QUEUE_URL（可选，请求 fifo=true 时使用的 FIFO 队列）、TASK_TOPIC_ARN（可选，transport=sns/sns-sqs 时使用的 SNS topic）、
+// EVENT_BUS_NAME（可选，transport=eventbridge 时使用的自定义事件总线）、WORKER_FUNCTION_NAME（可选，transport=lambda 时异步调用的 Worker）、
+// KINESIS_STREAM_NAME（可选，transport=kinesis 时使用的数据流）
+//
+// FIFO 队列（URL 以 .fifo 结尾）：设置 MessageGroupId（请求的 messageGroupId，默认 runId）与 MessageDeduplicationId（消息 id）。
+// FIFO 不支持按消息设置 DelaySeconds，延迟由状态机在 Dispatch 之前的 Wait 状态完成，Dispatcher 不再传递延迟。
 package main
 
 import (
@@ -23,21 +33,58 @@ import (
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	lambdasvc "github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"testsqs/internal/claimcheck"
+	"testsqs/internal/tasktable"
 )
 
 type Request struct {
 	TaskToken string `json:"taskToken"`
-	Input     struct {
+	// WaitForCompletion：Express workflow 使用，Dispatcher 同步等待 Worker 完成。
+	WaitForCompletion bool `json:"waitForCompletion,omitempty"`
+	// ExecutionArn：状态机传入的 $$.Execution.Id，记录到任务条目中。
+	ExecutionArn string `json:"executionArn,omitempty"`
+	// Branch：扇出状态机（Map）中的分支序号，只用于日志；单任务状态机不传。
+	Branch *int `json:"branch,omitempty"`
+	Input  struct {
 		RunID            string `json:"runId,omitempty"`
 		DelaySeconds     int    `json:"delaySeconds,omitempty"`
 		MessageBodyBytes int    `json:"messageBodyBytes,omitempty"`
+		WorkMs           int64  `json:"workMs,omitempty"`
+		// TaskType / TaskParams：透传给 Worker，选择处理器及其参数。
+		TaskType   string          `json:"taskType,omitempty"`
+		TaskParams json.RawMessage `json:"taskParams,omitempty"`
+		// ContentMode / Compression：填充内容（repeated/random/json）与消息体压缩方式（none/gzip/zstd），见 codec.go。
+		ContentMode string `json:"contentMode,omitempty"`
+		Compression string `json:"compression,omitempty"`
+		// Fifo / MessageGroupID：发送到 FIFO_QUEUE_URL；MessageGroupID 为空时取 runId。
+		Fifo           bool   `json:"fifo,omitempty"`
+		MessageGroupID string `json:"messageGroupId,omitempty"`
+		// Priority：目标 lane（例如 high/low），见 routing.go。
+		Priority string `json:"priority,omitempty"`
+		// Transport：sqs（默认）/sns/sns-sqs/eventbridge/lambda/kinesis，见 transport.go。
+		Transport string `json:"transport,omitempty"`
+		// Fanout：扇出状态机中的分支总数，透传给 Worker 按分支均分 Output 转存阈值。
+		Fanout int `json:"fanout,omitempty"`
+		// DispatchTimeoutSeconds：Dispatch 任务的超时；Express（WaitForCompletion）时也是等待完成记录的上限。
+		DispatchTimeoutSeconds int `json:"dispatchTimeoutSeconds,omitempty"`
 	} `json:"input"`
 }
 
 type Response struct {
 	QueueName string `json:"queueName"`
+	Lane      string `json:"lane,omitempty"`
+	Transport string `json:"transport,omitempty"`
 	Region    string `json:"region"`
 
 	RunID string `json:"runId"`
@@ -53,6 +100,33 @@ type Response struct {
 	SqsSentTimestampMs         int64 `json:"sqsSentTimestampMs"`
 	SqsFirstReceiveTimestampMs int64 `json:"sqsFirstReceiveTimestampMs"`
 	SqsApproxReceiveCount      int64 `json:"sqsApproxReceiveCount"`
+	SnsTimestampMs             int64 `json:"snsTimestampMs,omitempty"`
+	EventTimeMs                int64 `json:"eventTimeMs,omitempty"`
+	DuplicateDeliveries        int64 `json:"duplicateDeliveries,omitempty"`
+	LeaseTakeover              bool  `json:"leaseTakeover,omitempty"`
+	WorkMs                     int64 `json:"workMs,omitempty"`
+	Heartbeats                 int64 `json:"heartbeats,omitempty"`
+
+	// FIFO 队列：消息组与 SQS 分配的序列号。
+	MessageGroupID string `json:"messageGroupId,omitempty"`
+	SequenceNumber string `json:"sequenceNumber,omitempty"`
+
+	// Kinesis：记录到达流的时间与分片（SequenceNumber 为 Kinesis 序列号）。
+	KinesisArrivalTimestampMs int64  `json:"kinesisArrivalTimestampMs,omitempty"`
+	ShardID                   string `json:"shardId,omitempty"`
+
+	ContentMode string `json:"contentMode,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+	RawBytes    int64  `json:"rawBytes,omitempty"`
+	WireBytes   int64  `json:"wireBytes,omitempty"`
+	EncodeNanos int64  `json:"encodeNanos,omitempty"`
+	DecodeNanos int64  `json:"decodeNanos,omitempty"`
+
+	TaskType string         `json:"taskType,omitempty"`
+	Result   map[string]any `json:"result,omitempty"`
+
+	// OutputRef：Worker 的 callback Output 过大时被转存到 S3，此处只有指针，由 ApiFunction 解引用。
+	OutputRef *claimcheck.PayloadRef `json:"outputRef,omitempty"`
 }
 
 type msgBody struct {
@@ -61,7 +135,21 @@ type msgBody struct {
 	SendStartUnixNano int64  `json:"sendStartUnixNano"`
 	RunID             string `json:"runId"`
 	TaskToken         string `json:"taskToken"`
-	Padding           string `json:"padding,omitempty"`
+	// Reply：没有 taskToken 时 Worker 的结果回传方式；"ddb" 表示只写完成记录。
+	Reply      string          `json:"reply,omitempty"`
+	WorkMs     int64           `json:"workMs,omitempty"`
+	TaskType   string          `json:"taskType,omitempty"`
+	TaskParams json.RawMessage `json:"taskParams,omitempty"`
+	// Lane / Transport：Dispatcher 选择的路由通道与传输方式，Worker 写入 callback Output。
+	Lane      string `json:"lane,omitempty"`
+	Transport string `json:"transport,omitempty"`
+	// ContentMode：Padding 的内容模式（repeated/random/json），透传给 Worker 写入 callback Output。
+	ContentMode string `json:"contentMode,omitempty"`
+	Padding     string `json:"padding,omitempty"`
+	// Fanout：扇出执行的分支总数（单任务为 0）。
+	Fanout int `json:"fanout,omitempty"`
+	// PayloadRef：消息体过大时完整内容在 S3 中，队列里只保留 id/runId/taskToken 等字段与该指针。
+	PayloadRef *claimcheck.PayloadRef `json:"payloadRef,omitempty"`
 }
 
 var (
@@ -70,6 +158,13 @@ var (
 
 	awsCfg    = struct{ Region string }{}
 	sqsClient *sqs.Client
+	snsClient *sns.Client
+	ddbClient *dynamodb.Client
+	s3Client  *s3.Client
+
+	eventBridgeClient *eventbridge.Client
+	lambdaClient      *lambdasvc.Client
+	kinesisClient     *kinesis.Client
 )
 
 func initAWS() {
@@ -81,19 +176,25 @@ func initAWS() {
 		}
 		awsCfg.Region = cfg.Region
 		sqsClient = sqs.NewFromConfig(cfg)
+		snsClient = sns.NewFromConfig(cfg)
+		eventBridgeClient = eventbridge.NewFromConfig(cfg)
+		lambdaClient = lambdasvc.NewFromConfig(cfg)
+		kinesisClient = kinesis.NewFromConfig(cfg)
+		ddbClient = dynamodb.NewFromConfig(cfg)
+		s3Client = claimcheck.NewS3Client(cfg)
 	})
 }
 
 func handler(ctx context.Context, req Request) (Response, error) {
 	// Standard workflow：状态机使用 waitForTaskToken；Dispatcher 只负责把 taskToken 放进请求队列，Worker 处理后回调解除阻塞。
-	requestQueueURL := os.Getenv("REQUEST_QUEUE_URL")
-	if requestQueueURL == "" {
-		return Response{}, errors.New("missing env REQUEST_QUEUE_URL")
+	tr, lane, err := resolveTransport(req)
+	if err != nil {
+		return Response{}, err
 	}
 	if initErr != nil {
 		return Response{}, initErr
 	}
-	if strings.TrimSpace(req.TaskToken) == "" {
+	if strings.TrimSpace(req.TaskToken) == "" && !req.WaitForCompletion {
 		return Response{}, errors.New("missing taskToken in request")
 	}
 	if req.Input.DelaySeconds < 0 {
@@ -105,14 +206,33 @@ func handler(ctx context.Context, req Request) (Response, error) {
 	if req.Input.MessageBodyBytes < 0 {
 		req.Input.MessageBodyBytes = 0
 	}
+	if req.Input.WorkMs < 0 {
+		req.Input.WorkMs = 0
+	}
 	if strings.TrimSpace(req.Input.RunID) == "" {
 		req.Input.RunID = randHex(12)
 	}
+	contentMode, err := normalizeContentMode(req.Input.ContentMode)
+	if err != nil {
+		return Response{}, err
+	}
+	compression, err := normalizeCompression(req.Input.Compression)
+	if err != nil {
+		return Response{}, err
+	}
 
-	qn := queueNameFromURL(requestQueueURL)
+	qn := tr.Destination()
 
 	// 生成消息体：包含唯一 id、发送时间戳；Worker 处理后把结果发回 response queue。
 	messageID := randHex(16)
+	// 任务条目：发送前创建为 pending（在记录发送时间戳之前，不计入 sendToSqsMs），Worker 据此推进 processing -> succeeded/failed。
+	// 审计失败不阻断发送（Worker 的条件更新兼容没有 pending 条目的消息）。
+	tableName := strings.TrimSpace(os.Getenv("TABLE_NAME"))
+	if tableName != "" {
+		if err := createPendingItem(ctx, tableName, messageID, req.Input.RunID, req.ExecutionArn); err != nil {
+			log.Printf("create pending item failed id=%s: %v", messageID, err)
+		}
+	}
 	sendUnixNano := time.Now().UnixNano()
 	sendStart := time.Now().UnixNano()
 
@@ -122,26 +242,85 @@ func handler(ctx context.Context, req Request) (Response, error) {
 		SendStartUnixNano: sendStart,
 		RunID:             req.Input.RunID,
 		TaskToken:         req.TaskToken,
-		Padding:           makePadding(req.Input.MessageBodyBytes),
+		WorkMs:            req.Input.WorkMs,
+		TaskType:          req.Input.TaskType,
+		TaskParams:        req.Input.TaskParams,
+		Lane:              lane,
+		Transport:         tr.Name(),
+		ContentMode:       contentMode,
+		Padding:           makePadding(req.Input.MessageBodyBytes, contentMode),
+		Fanout:            req.Input.Fanout,
+	}
+	if req.WaitForCompletion {
+		bodyObj.Reply = "ddb"
+	}
+	rawBytes, _ := json.Marshal(bodyObj)
+	// 压缩 + base64（编码耗时计入 sendToSqsMs，并通过消息属性单独记录）。
+	encoded, err := encodeBody(rawBytes, compression)
+	if err != nil {
+		return Response{}, err
+	}
+	bodyBytes := encoded.Body
+	attrs := encoded.attributes()
+	// claim-check：超过 SQS 上限的（编码后）消息体转存 S3（计入 sendToSqsMs），队列中只发送未编码的指针消息，编码方式记录在指针中。
+	if len(bodyBytes) > claimcheck.ThresholdBytes() {
+		ref, err := offloadPayload(ctx, "messages", messageID, bodyBytes, encoded.Encoding)
+		if err != nil {
+			return Response{}, err
+		}
+		delete(attrs, "contentEncoding")
+		bodyBytes, _ = json.Marshal(msgBody{
+			ID:                bodyObj.ID,
+			SendUnixNano:      bodyObj.SendUnixNano,
+			SendStartUnixNano: bodyObj.SendStartUnixNano,
+			RunID:             bodyObj.RunID,
+			TaskToken:         bodyObj.TaskToken,
+			Reply:             bodyObj.Reply,
+			Transport:         bodyObj.Transport,
+			PayloadRef:        ref,
+		})
+		log.Printf("offloaded message body id=%s bytes=%d s3://%s/%s", messageID, ref.Bytes, ref.Bucket, ref.Key)
 	}
-	bodyBytes, _ := json.Marshal(bodyObj)
-	body := string(bodyBytes)
 
-	_, err := sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
-		QueueUrl:     &requestQueueURL,
-		MessageBody:  &body,
-		DelaySeconds: int32(req.Input.DelaySeconds),
+	err = tr.Send(ctx, outboundMessage{
+		ID:             messageID,
+		RunID:          req.Input.RunID,
+		Body:           string(bodyBytes),
+		Attributes:     attrs,
+		DelaySeconds:   req.Input.DelaySeconds,
+		MessageGroupID: req.Input.MessageGroupID,
 	})
 	sendEnd := time.Now().UnixNano()
 	if err != nil {
-		return Response{}, fmt.Errorf("send message: %w", err)
+		return Response{}, err
 	}
 
 	// Lambda 日志：便于排查（测试日志仍由测试用例输出）。
-	log.Printf("sent request id=%s queue=%s sendUnixNano=%d sendStartUnixNano=%d sendEndUnixNano=%d", messageID, qn, sendUnixNano, sendStart, sendEnd)
+	branch := ""
+	if req.Branch != nil {
+		branch = fmt.Sprintf(" branch=%d", *req.Branch)
+	}
+	log.Printf("sent request id=%s%s transport=%s queue=%s lane=%s sendUnixNano=%d sendStartUnixNano=%d sendEndUnixNano=%d", messageID, branch, tr.Name(), qn, lane, sendUnixNano, sendStart, sendEnd)
+
+	if tableName != "" {
+		if err := recordSendTimes(ctx, tableName, messageID, sendUnixNano, sendStart, sendEnd); err != nil {
+			log.Printf("record send times failed id=%s: %v", messageID, err)
+		}
+	}
+
+	if req.WaitForCompletion {
+		out, err := waitForCompletionRecord(ctx, req.Input.RunID, messageID, time.Duration(req.Input.DispatchTimeoutSeconds)*time.Second)
+		if err != nil {
+			return Response{}, err
+		}
+		out.SendEndUnixNano = sendEnd
+		return out, nil
+	}
 
 	return Response{
 		QueueName:         qn,
+		Lane:              lane,
+		Transport:         tr.Name(),
 		Region:            awsCfg.Region,
 		RunID:             req.Input.RunID,
 		ID:                messageID,
@@ -151,20 +330,115 @@ func handler(ctx context.Context, req Request) (Response, error) {
 	}, nil
 }
 
-func queueNameFromURL(queueURL string) string {
-	base := strings.SplitN(queueURL, "?", 2)[0]
-	return path.Base(base)
+// createPendingItem 创建 pending 状态的任务条目（id=消息 id），带 TTL 属性 expiresAt。
+func createPendingItem(ctx context.Context, tableName, id, runID, executionArn string) error {
+	item := map[string]dynamodbtypes.AttributeValue{
+		"id":              &dynamodbtypes.AttributeValueMemberS{Value: id},
+		"status":          &dynamodbtypes.AttributeValueMemberS{Value: "pending"},
+		"runId":           &dynamodbtypes.AttributeValueMemberS{Value: runID},
+		"createdUnixNano": &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().UnixNano())},
+		"expiresAt":       &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", tasktable.ExpiresAt())},
+	}
+	if executionArn != "" {
+		item["executionArn"] = &dynamodbtypes.AttributeValueMemberS{Value: executionArn}
+	}
+	_, err := ddbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	return err
 }
 
-func makePadding(extraBytes int) string {
-	if extraBytes <= 0 {
-		return ""
+// recordSendTimes 在发送成功后补记发送时间戳（不改变状态：Worker 可能已经把条目推进到 processing）。
+func recordSendTimes(ctx context.Context, tableName, id string, sendUnixNano, sendStart, sendEnd int64) error {
+	_, err := ddbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]dynamodbtypes.AttributeValue{
+			"id": &dynamodbtypes.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET #send = :send, #sendStart = :sendStart, #sendEnd = :sendEnd"),
+		ExpressionAttributeNames: map[string]string{
+			"#send":      "sendUnixNano",
+			"#sendStart": "sendStartUnixNano",
+			"#sendEnd":   "sendEndUnixNano",
+		},
+		ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{
+			":send":      &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", sendUnixNano)},
+			":sendStart": &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", sendStart)},
+			":sendEnd":   &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", sendEnd)},
+		},
+	})
+	return err
+}
+
+// waitForCompletionRecord 以退避方式读取 Worker 写入的完成记录，直到出现、超过 budget（>0 时）或 ctx 截止（预留 250ms 余量）。
+// 同一 runId 的旧记录（例如客户端重试）通过 messageId 区分，只接受本次发送的消息对应的记录。
+func waitForCompletionRecord(ctx context.Context, runID, messageID string, budget time.Duration) (Response, error) {
+	tableName := strings.TrimSpace(os.Getenv("TABLE_NAME"))
+	if tableName == "" {
+		return Response{}, errors.New("missing env TABLE_NAME")
 	}
-	pad := make([]byte, extraBytes)
-	for i := range pad {
-		pad[i] = 'x'
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
 	}
-	return string(pad)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-250*time.Millisecond))
+		defer cancel()
+	}
+
+	interval := 10 * time.Millisecond
+	for {
+		out, err := ddbClient.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(tableName),
+			ConsistentRead: aws.Bool(true),
+			Key: map[string]dynamodbtypes.AttributeValue{
+				"id": &dynamodbtypes.AttributeValueMemberS{Value: tasktable.CompletionRecordKey(runID)},
+			},
+		})
+		if err != nil {
+			return Response{}, fmt.Errorf("get completion record: %w", err)
+		}
+		if mid, ok := out.Item["messageId"].(*dynamodbtypes.AttributeValueMemberS); ok && mid.Value == messageID {
+			// Worker 上报失败（FAILED）：以其错误码/原因让 Express 执行快速失败。
+			if st := stringAttr(out.Item, "status"); st != "SUCCEEDED" {
+				return Response{}, fmt.Errorf("%s: %s", stringAttr(out.Item, "error"), stringAttr(out.Item, "cause"))
+			}
+			var resp Response
+			if err := json.Unmarshal([]byte(stringAttr(out.Item, "output")), &resp); err != nil {
+				return Response{}, fmt.Errorf("unmarshal completion output: %w", err)
+			}
+			return resp, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return Response{}, fmt.Errorf("wait for completion record runId=%s: %w", runID, ctx.Err())
+		case <-time.After(interval):
+		}
+		if interval < 100*time.Millisecond {
+			interval *= 2
+		}
+	}
+}
+
+func stringAttr(item map[string]dynamodbtypes.AttributeValue, name string) string {
+	if v, ok := item[name].(*dynamodbtypes.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func isFifoQueue(queueURL string) bool {
+	return strings.HasSuffix(queueNameFromURL(queueURL), ".fifo")
+}
+
+func queueNameFromURL(queueURL string) string {
+	base := strings.SplitN(queueURL, "?", 2)[0]
+	return path.Base(base)
 }
 
 func randHex(n int) string {
@@ -0,0 +1,79 @@
+package main
+
+// 多队列路由（lane）：QUEUE_ROUTES 环境变量（JSON）把请求映射到不同队列，例如高/低优先级通道：
+//
+//	{"lanes": {"high": "<queueUrl>", "low": "<queueUrl>"}, "taskTypes": {"cpu": "low"}}
+//
+// 选择顺序：fifo=true 使用 FIFO_QUEUE_URL（lane=fifo）；请求 priority 指定 lane；taskTypes 按 taskType 映射 lane；
+// 否则使用 REQUEST_QUEUE_URL（lane=default）。lane 名写入消息体，由 Worker 回传到 callback Output。
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	laneDefault = "default"
+	laneFifo    = "fifo"
+)
+
+type queueRoutes struct {
+	Lanes     map[string]string `json:"lanes"`
+	TaskTypes map[string]string `json:"taskTypes"`
+}
+
+func loadQueueRoutes() (queueRoutes, error) {
+	var routes queueRoutes
+	raw := strings.TrimSpace(os.Getenv("QUEUE_ROUTES"))
+	if raw == "" {
+		return routes, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &routes); err != nil {
+		return routes, fmt.Errorf("invalid env QUEUE_ROUTES: %w", err)
+	}
+	return routes, nil
+}
+
+// resolveQueue 返回本次请求使用的 lane 与队列 URL。
+func resolveQueue(priority, taskType string, fifo bool) (string, string, error) {
+	if fifo {
+		url := strings.TrimSpace(os.Getenv("FIFO_QUEUE_URL"))
+		if url == "" {
+			return "", "", fmt.Errorf("fifo requested but env FIFO_QUEUE_URL is not configured")
+		}
+		return laneFifo, url, nil
+	}
+	defaultURL := strings.TrimSpace(os.Getenv("REQUEST_QUEUE_URL"))
+	if defaultURL == "" {
+		return "", "", fmt.Errorf("missing env REQUEST_QUEUE_URL")
+	}
+
+	routes, err := loadQueueRoutes()
+	if err != nil {
+		return "", "", err
+	}
+	lane := strings.ToLower(strings.TrimSpace(priority))
+	if lane == "" {
+		lane = routes.TaskTypes[strings.ToLower(strings.TrimSpace(taskType))]
+	}
+	if lane == "" || lane == laneDefault {
+		return laneDefault, defaultURL, nil
+	}
+	url, ok := routes.Lanes[lane]
+	if !ok || strings.TrimSpace(url) == "" {
+		return "", "", fmt.Errorf("unknown lane %q (configured: %s)", lane, strings.Join(laneNames(routes), ", "))
+	}
+	return lane, url, nil
+}
+
+func laneNames(routes queueRoutes) []string {
+	names := make([]string, 0, len(routes.Lanes))
+	for name := range routes.Lanes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{laneDefault}, names...)
+}
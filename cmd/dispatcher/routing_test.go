@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveQueue(t *testing.T) {
+	const (
+		defaultURL = "https://sqs.us-east-1.amazonaws.com/123456789012/requests"
+		highURL    = "https://sqs.us-east-1.amazonaws.com/123456789012/requests-high"
+		lowURL     = "https://sqs.us-east-1.amazonaws.com/123456789012/requests-low"
+		fifoURL    = "https://sqs.us-east-1.amazonaws.com/123456789012/requests.fifo"
+	)
+	routes := `{"lanes": {"high": "` + highURL + `", "low": "` + lowURL + `", "empty": " "}, "taskTypes": {"cpu": "low"}}`
+	cases := []struct {
+		name     string
+		routes   string
+		fifoURL  string
+		priority string
+		taskType string
+		fifo     bool
+		wantLane string
+		wantURL  string
+		wantErr  string
+	}{
+		{name: "no routes", wantLane: laneDefault, wantURL: defaultURL},
+		{name: "no routes with priority", priority: "high", wantErr: `unknown lane "high" (configured: default)`},
+		{name: "priority", routes: routes, priority: "high", wantLane: "high", wantURL: highURL},
+		{name: "priority normalized", routes: routes, priority: " HIGH ", wantLane: "high", wantURL: highURL},
+		{name: "priority beats taskType", routes: routes, priority: "high", taskType: "cpu", wantLane: "high", wantURL: highURL},
+		{name: "taskType mapping", routes: routes, taskType: "CPU", wantLane: "low", wantURL: lowURL},
+		{name: "unmapped taskType", routes: routes, taskType: "sleep", wantLane: laneDefault, wantURL: defaultURL},
+		{name: "explicit default", routes: routes, priority: "default", wantLane: laneDefault, wantURL: defaultURL},
+		{name: "unknown lane", routes: routes, priority: "urgent", wantErr: `unknown lane "urgent" (configured: default, empty, high, low)`},
+		{name: "blank lane url", routes: routes, priority: "empty", wantErr: `unknown lane "empty"`},
+		{name: "invalid routes", routes: `{"lanes":`, wantErr: "invalid env QUEUE_ROUTES"},
+		{name: "fifo", routes: routes, fifoURL: fifoURL, priority: "high", fifo: true, wantLane: laneFifo, wantURL: fifoURL},
+		{name: "fifo not configured", fifo: true, wantErr: "FIFO_QUEUE_URL"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("REQUEST_QUEUE_URL", defaultURL)
+			t.Setenv("QUEUE_ROUTES", tc.routes)
+			t.Setenv("FIFO_QUEUE_URL", tc.fifoURL)
+			lane, url, err := resolveQueue(tc.priority, tc.taskType, tc.fifo)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("resolveQueue error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || lane != tc.wantLane || url != tc.wantURL {
+				t.Fatalf("resolveQueue = %q, %q, %v; want %q, %q", lane, url, err, tc.wantLane, tc.wantURL)
+			}
+		})
+	}
+
+	t.Setenv("REQUEST_QUEUE_URL", "")
+	if _, _, err := resolveQueue("", "", false); err == nil || !strings.Contains(err.Error(), "REQUEST_QUEUE_URL") {
+		t.Errorf("resolveQueue without REQUEST_QUEUE_URL error = %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+// 传输方式（请求 transport 字段）：Dispatcher 把带 taskToken 的消息体交给 Worker 的途径。
+//   - sqs（默认）：SendMessage 到按 lane 选择的队列（见 routing.go）；
+//   - sns：Publish 到 TASK_TOPIC_ARN，Worker 直接订阅 topic（消息属性 delivery=lambda）；
+//   - sns-sqs：Publish 到同一个 topic（delivery=sqs），由订阅的 TestSnsQueue 转交 Worker（非 raw 投递，保留 SNS Timestamp）。
+//   - eventbridge：PutEvents 到自定义事件总线 EVENT_BUS_NAME（source=testsqs.dispatcher，detail-type=Task），由规则调用 Worker；
+//   - lambda：以 InvocationType=Event 直接异步调用 WORKER_FUNCTION_NAME，不经过任何队列，作为对比基线。
+//     未编码的消息体原样作为调用 payload；编码后的消息体包在 bodyEnvelope 中；
+//   - kinesis：PutRecord 到 KINESIS_STREAM_NAME，分区键为 runId（同一 run 的消息落在同一个分片、保持顺序），数据为 bodyEnvelope。
+// 两种 SNS 方式都由订阅的 FilterPolicy 按 delivery 属性分流，互不重复投递。
+// SNS / EventBridge / Lambda 异步调用 / Kinesis 没有按消息延迟，delaySeconds 由状态机在 Dispatch 之前的 Wait 状态完成。
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventbridgetypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	lambdasvc "github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	transportSQS         = "sqs"
+	transportSNS         = "sns"
+	transportSNSSQS      = "sns-sqs"
+	transportEventBridge = "eventbridge"
+	transportLambda      = "lambda"
+	transportKinesis     = "kinesis"
+
+	// EventBridge 事件的 source / detail-type，与模板中规则的 EventPattern 一致。
+	eventSource     = "testsqs.dispatcher"
+	eventDetailType = "Task"
+)
+
+// outboundMessage 是交给 transport 发送的消息（已完成编码与 claim-check）。
+type outboundMessage struct {
+	ID    string
+	RunID string
+	Body  string
+	// Attributes：描述消息体编码的属性（contentEncoding/rawBytes/encodeNanos），各 transport 以自己的消息属性携带。
+	Attributes     map[string]string
+	DelaySeconds   int
+	MessageGroupID string
+}
+
+// transport 把消息发送到 Worker 的某个事件源。
+type transport interface {
+	// Name 返回 transport 名称（sqs/sns/sns-sqs/eventbridge/lambda/kinesis），写入消息体，由 Worker 回传。
+	Name() string
+	// Destination 返回目的地名称（队列名、topic 名），写入 Response.QueueName 与日志。
+	Destination() string
+	Send(ctx context.Context, msg outboundMessage) error
+}
+
+// normalizeTransport 校验并规范化 transport，空值为 sqs。
+func normalizeTransport(v string) (string, error) {
+	switch t := strings.ToLower(strings.TrimSpace(v)); t {
+	case "", transportSQS:
+		return transportSQS, nil
+	case transportSNS, transportSNSSQS, transportEventBridge, transportLambda, transportKinesis:
+		return t, nil
+	default:
+		return "", fmt.Errorf("invalid transport %q (want sqs, sns, sns-sqs, eventbridge, lambda or kinesis)", v)
+	}
+}
+
+// resolveTransport 返回本次请求使用的 transport 与 lane。lane 路由（priority/taskType/fifo）只适用于 sqs。
+func resolveTransport(req Request) (transport, string, error) {
+	name, err := normalizeTransport(req.Input.Transport)
+	if err != nil {
+		return nil, "", err
+	}
+	if name == transportSQS {
+		lane, queueURL, err := resolveQueue(req.Input.Priority, req.Input.TaskType, req.Input.Fifo)
+		if err != nil {
+			return nil, "", err
+		}
+		return &sqsTransport{queueURL: queueURL}, lane, nil
+	}
+	if req.Input.Fifo || strings.TrimSpace(req.Input.Priority) != "" {
+		return nil, "", fmt.Errorf("fifo and priority require transport %q, got %q", transportSQS, name)
+	}
+	if name == transportEventBridge {
+		busName := strings.TrimSpace(os.Getenv("EVENT_BUS_NAME"))
+		if busName == "" {
+			return nil, "", fmt.Errorf("transport %q requested but env EVENT_BUS_NAME is not configured", name)
+		}
+		return &eventBridgeTransport{busName: busName}, laneDefault, nil
+	}
+	if name == transportLambda {
+		functionName := strings.TrimSpace(os.Getenv("WORKER_FUNCTION_NAME"))
+		if functionName == "" {
+			return nil, "", fmt.Errorf("transport %q requested but env WORKER_FUNCTION_NAME is not configured", name)
+		}
+		return &lambdaTransport{functionName: functionName}, laneDefault, nil
+	}
+	if name == transportKinesis {
+		streamName := strings.TrimSpace(os.Getenv("KINESIS_STREAM_NAME"))
+		if streamName == "" {
+			return nil, "", fmt.Errorf("transport %q requested but env KINESIS_STREAM_NAME is not configured", name)
+		}
+		return &kinesisTransport{streamName: streamName}, laneDefault, nil
+	}
+	topicArn := strings.TrimSpace(os.Getenv("TASK_TOPIC_ARN"))
+	if topicArn == "" {
+		return nil, "", fmt.Errorf("transport %q requested but env TASK_TOPIC_ARN is not configured", name)
+	}
+	delivery := "lambda"
+	if name == transportSNSSQS {
+		delivery = "sqs"
+	}
+	return &snsTransport{name: name, topicArn: topicArn, delivery: delivery}, laneDefault, nil
+}
+
+// numericAttributes 是以 Number 类型发送的消息属性。
+var numericAttributes = map[string]bool{"rawBytes": true, "encodeNanos": true}
+
+func attributeDataType(name string) string {
+	if numericAttributes[name] {
+		return "Number"
+	}
+	return "String"
+}
+
+type sqsTransport struct {
+	queueURL string
+}
+
+func (t *sqsTransport) Name() string        { return transportSQS }
+func (t *sqsTransport) Destination() string { return queueNameFromURL(t.queueURL) }
+
+// Send 发送到 SQS；FIFO 队列设置 MessageGroupId（默认 runId）与 MessageDeduplicationId（消息 id），不设置延迟。
+func (t *sqsTransport) Send(ctx context.Context, msg outboundMessage) error {
+	attrs := make(map[string]sqstypes.MessageAttributeValue, len(msg.Attributes))
+	for name, v := range msg.Attributes {
+		attrs[name] = sqstypes.MessageAttributeValue{DataType: aws.String(attributeDataType(name)), StringValue: aws.String(v)}
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(t.queueURL),
+		MessageBody:       aws.String(msg.Body),
+		DelaySeconds:      int32(msg.DelaySeconds),
+		MessageAttributes: attrs,
+	}
+	if isFifoQueue(t.queueURL) {
+		groupID := strings.TrimSpace(msg.MessageGroupID)
+		if groupID == "" {
+			groupID = msg.RunID
+		}
+		input.MessageGroupId = aws.String(groupID)
+		input.MessageDeduplicationId = aws.String(msg.ID)
+		input.DelaySeconds = 0
+	}
+	if _, err := sqsClient.SendMessage(ctx, input); err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+	return nil
+}
+
+type snsTransport struct {
+	name     string
+	topicArn string
+	// delivery：订阅 FilterPolicy 匹配的消息属性值（lambda / sqs）。
+	delivery string
+}
+
+func (t *snsTransport) Name() string { return t.name }
+
+func (t *snsTransport) Destination() string {
+	// arn:aws:sns:region:account:topicName
+	parts := strings.Split(t.topicArn, ":")
+	return parts[len(parts)-1]
+}
+
+func (t *snsTransport) Send(ctx context.Context, msg outboundMessage) error {
+	attrs := make(map[string]snstypes.MessageAttributeValue, len(msg.Attributes)+1)
+	for name, v := range msg.Attributes {
+		attrs[name] = snstypes.MessageAttributeValue{DataType: aws.String(attributeDataType(name)), StringValue: aws.String(v)}
+	}
+	attrs["delivery"] = snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(t.delivery)}
+	if _, err := snsClient.Publish(ctx, &sns.PublishInput{
+		TopicArn:          aws.String(t.topicArn),
+		Message:           aws.String(msg.Body),
+		MessageAttributes: attrs,
+	}); err != nil {
+		return fmt.Errorf("publish message: %w", err)
+	}
+	return nil
+}
+
+// bodyEnvelope 携带消息体与消息属性（EventBridge 事件的 detail、Kinesis 记录的数据、Lambda 调用中编码后的消息体）：
+// 未编码的消息体直接作为 JSON 对象（EventBridge 规则可以按 detail.body.* 过滤），编码后的消息体（base64）作为 JSON 字符串。
+type bodyEnvelope struct {
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Body       json.RawMessage   `json:"body"`
+}
+
+func newBodyEnvelope(msg outboundMessage) ([]byte, error) {
+	body := json.RawMessage(msg.Body)
+	if msg.Attributes["contentEncoding"] != "" {
+		body, _ = json.Marshal(msg.Body)
+	}
+	return json.Marshal(bodyEnvelope{Attributes: msg.Attributes, Body: body})
+}
+
+type eventBridgeTransport struct {
+	busName string
+}
+
+func (t *eventBridgeTransport) Name() string        { return transportEventBridge }
+func (t *eventBridgeTransport) Destination() string { return t.busName }
+
+func (t *eventBridgeTransport) Send(ctx context.Context, msg outboundMessage) error {
+	detail, err := newBodyEnvelope(msg)
+	if err != nil {
+		return fmt.Errorf("marshal event detail: %w", err)
+	}
+	out, err := eventBridgeClient.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []eventbridgetypes.PutEventsRequestEntry{{
+			EventBusName: aws.String(t.busName),
+			Source:       aws.String(eventSource),
+			DetailType:   aws.String(eventDetailType),
+			Detail:       aws.String(string(detail)),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("put events: %w", err)
+	}
+	// PutEvents 按条目报告失败，整体调用仍然成功。
+	if out.FailedEntryCount > 0 && len(out.Entries) > 0 {
+		e := out.Entries[0]
+		return fmt.Errorf("put events: %s: %s", aws.ToString(e.ErrorCode), aws.ToString(e.ErrorMessage))
+	}
+	return nil
+}
+
+type lambdaTransport struct {
+	functionName string
+}
+
+func (t *lambdaTransport) Name() string        { return transportLambda }
+func (t *lambdaTransport) Destination() string { return t.functionName }
+
+// Send 异步调用 Worker（返回时请求已进入 Lambda 的异步调用队列）。
+func (t *lambdaTransport) Send(ctx context.Context, msg outboundMessage) error {
+	payload := []byte(msg.Body)
+	if msg.Attributes["contentEncoding"] != "" {
+		var err error
+		if payload, err = newBodyEnvelope(msg); err != nil {
+			return fmt.Errorf("marshal invoke payload: %w", err)
+		}
+	}
+	_, err := lambdaClient.Invoke(ctx, &lambdasvc.InvokeInput{
+		FunctionName:   aws.String(t.functionName),
+		InvocationType: lambdatypes.InvocationTypeEvent,
+		Payload:        payload,
+	})
+	if err != nil {
+		return fmt.Errorf("invoke worker: %w", err)
+	}
+	return nil
+}
+
+type kinesisTransport struct {
+	streamName string
+}
+
+func (t *kinesisTransport) Name() string        { return transportKinesis }
+func (t *kinesisTransport) Destination() string { return t.streamName }
+
+func (t *kinesisTransport) Send(ctx context.Context, msg outboundMessage) error {
+	data, err := newBodyEnvelope(msg)
+	if err != nil {
+		return fmt.Errorf("marshal record data: %w", err)
+	}
+	out, err := kinesisClient.PutRecord(ctx, &kinesis.PutRecordInput{
+		StreamName:   aws.String(t.streamName),
+		PartitionKey: aws.String(msg.RunID),
+		Data:         data,
+	})
+	if err != nil {
+		return fmt.Errorf("put record: %w", err)
+	}
+	log.Printf("put record id=%s shard=%s sequenceNumber=%s", msg.ID, aws.ToString(out.ShardId), aws.ToString(out.SequenceNumber))
+	return nil
+}
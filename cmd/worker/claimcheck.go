@@ -0,0 +1,68 @@
+package main
+
+// 大消息 claim-check（与 cmd/dispatcher/claimcheck.go 对应）：
+//   - 消息体带 payloadRef 时，从 S3 取回完整消息体再处理；
+//   - callback Output 超过 PAYLOAD_OFFLOAD_THRESHOLD_BYTES（默认 256000；Step Functions 任务输出上限 256KB）时，
+//     完整 Output 写入 PAYLOAD_BUCKET，回调只带 outputRef 指针，由 ApiFunction 解引用；
+//     扇出执行的 N 个分支 Output 共用这一上限，每个分支的阈值为 1/N。
+// S3_ENDPOINT_URL 可指向本地 S3 兼容服务（path-style）。
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"testsqs/internal/claimcheck"
+)
+
+// resolvePayload 取回 payloadRef 指向的完整消息体（按 ref.Encoding 解码），覆盖到 body 上，并把对象大小与解码耗时记入 stats。
+// 对象不存在或内容无法解析属于消息本身的问题，返回 *taskError；其它错误（网络/权限）返回普通 error，交给 SQS 重投。
+func resolvePayload(ctx context.Context, body *msgBody, stats *payloadStats) error {
+	ref := body.PayloadRef
+	b, err := claimcheck.Fetch(ctx, s3Client, ref)
+	if err != nil {
+		var noKey *s3types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return &taskError{Code: errInvalidMessage, Cause: fmt.Sprintf("payload s3://%s/%s not found", ref.Bucket, ref.Key)}
+		}
+		return fmt.Errorf("resolve payload: %w", err)
+	}
+
+	stats.Encoding = ref.Encoding
+	stats.WireBytes = int64(len(b))
+	start := time.Now()
+	b, err = decodeBody(ref.Encoding, b)
+	if err != nil {
+		return &taskError{Code: errInvalidMessage, Cause: fmt.Sprintf("decode payload s3://%s/%s: %v", ref.Bucket, ref.Key, err)}
+	}
+	if ref.Encoding != "" {
+		stats.DecodeNanos = time.Since(start).Nanoseconds()
+	}
+
+	var full msgBody
+	if err := json.Unmarshal(b, &full); err != nil {
+		return &taskError{Code: errInvalidMessage, Cause: fmt.Sprintf("unmarshal payload s3://%s/%s: %v", ref.Bucket, ref.Key, err)}
+	}
+	if full.ID != body.ID {
+		return &taskError{Code: errInvalidMessage, Cause: fmt.Sprintf("payload id %q does not match message id %q", full.ID, body.ID)}
+	}
+	full.PayloadRef = ref
+	*body = full
+	return nil
+}
+
+// offloadOutput 在 Output 超过阈值（fanout>1 时为 1/fanout）时把它写入 PAYLOAD_BUCKET 的 outputs/<id>.json，返回实际回调的（指针）Output。
+func offloadOutput(ctx context.Context, id, runID string, fanout int, output []byte) ([]byte, error) {
+	if len(output) <= claimcheck.OutputThresholdBytes(fanout) {
+		return output, nil
+	}
+	ref, err := claimcheck.Offload(ctx, s3Client, fmt.Sprintf("outputs/%s.json", id), output, "")
+	if err != nil {
+		return nil, fmt.Errorf("offload callback output: %w", err)
+	}
+	return json.Marshal(claimcheck.OffloadedOutput{ID: id, RunID: runID, OutputRef: ref})
+}
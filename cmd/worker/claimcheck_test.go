@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+
+	"testsqs/internal/claimcheck"
+)
+
+// fakeS3 是只支持 path-style PutObject/GetObject 的内存 S3，对象以 /<bucket>/<key> 为键。
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = b
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Write(b)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// useFakeS3 启动 fakeS3，并把包级 s3Client 指向它（S3_ENDPOINT_URL，path-style）。
+func useFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	fake := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	t.Setenv("S3_ENDPOINT_URL", srv.URL)
+	t.Setenv("PAYLOAD_BUCKET", "payloads")
+
+	prev := s3Client
+	s3Client = claimcheck.NewS3Client(aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test", "test", ""),
+	})
+	t.Cleanup(func() { s3Client = prev })
+	return fake
+}
+
+func TestResolvePayload(t *testing.T) {
+	fake := useFakeS3(t)
+	ctx := context.Background()
+	full := msgBody{ID: "m-1", RunID: "r-1", TaskToken: "token", Padding: strings.Repeat("x", 300000)}
+	b, err := json.Marshal(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.objects["/payloads/messages/m-1.json"] = b
+	fake.objects["/payloads/messages/bad.json"] = []byte("not json")
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(b)
+	zw.Close()
+	encoded := []byte(base64.StdEncoding.EncodeToString(gz.Bytes()))
+	fake.objects["/payloads/messages/m-1.gz.json"] = encoded
+
+	cases := []struct {
+		name     string
+		ref      claimcheck.PayloadRef
+		id       string
+		wantCode string
+	}{
+		{name: "found", ref: claimcheck.PayloadRef{Bucket: "payloads", Key: "messages/m-1.json", Bytes: len(b)}, id: "m-1"},
+		{name: "gzip", ref: claimcheck.PayloadRef{Bucket: "payloads", Key: "messages/m-1.gz.json", Bytes: len(encoded), Encoding: "gzip+base64"}, id: "m-1"},
+		{name: "undecodable", ref: claimcheck.PayloadRef{Bucket: "payloads", Key: "messages/m-1.json", Encoding: "gzip+base64"}, id: "m-1", wantCode: errInvalidMessage},
+		{name: "missing", ref: claimcheck.PayloadRef{Bucket: "payloads", Key: "messages/missing.json"}, id: "m-1", wantCode: errInvalidMessage},
+		{name: "invalid json", ref: claimcheck.PayloadRef{Bucket: "payloads", Key: "messages/bad.json"}, id: "m-1", wantCode: errInvalidMessage},
+		{name: "id mismatch", ref: claimcheck.PayloadRef{Bucket: "payloads", Key: "messages/m-1.json"}, id: "m-2", wantCode: errInvalidMessage},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ref := tc.ref
+			body := msgBody{ID: tc.id, SendUnixNano: 42, PayloadRef: &ref}
+			var stats payloadStats
+			err := resolvePayload(ctx, &body, &stats)
+			if tc.wantCode != "" {
+				var te *taskError
+				if !errors.As(err, &te) || te.Code != tc.wantCode {
+					t.Fatalf("resolvePayload error = %v, want taskError %s", err, tc.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolvePayload: %v", err)
+			}
+			// 完整消息体覆盖指针消息，但保留 payloadRef 以便记录来源。
+			if body.TaskToken != full.TaskToken || body.Padding != full.Padding || body.PayloadRef == nil || *body.PayloadRef != ref {
+				t.Fatalf("resolved body = {id:%s token:%s padding:%d ref:%v}", body.ID, body.TaskToken, len(body.Padding), body.PayloadRef)
+			}
+			if stats.Encoding != ref.Encoding || stats.WireBytes != int64(ref.Bytes) {
+				t.Fatalf("stats = %+v, want encoding %q wireBytes %d", stats, ref.Encoding, ref.Bytes)
+			}
+		})
+	}
+}
+
+func TestOffloadOutput(t *testing.T) {
+	fake := useFakeS3(t)
+	ctx := context.Background()
+	t.Setenv("PAYLOAD_OFFLOAD_THRESHOLD_BYTES", "64")
+
+	small := []byte(`{"id":"m-1","runId":"r-1"}`)
+	got, err := offloadOutput(ctx, "m-1", "r-1", 0, small)
+	if err != nil || !bytes.Equal(got, small) {
+		t.Fatalf("offloadOutput(small) = %s, %v; want unchanged", got, err)
+	}
+
+	large := []byte(`{"id":"m-2","runId":"r-1","padding":"` + strings.Repeat("y", 100) + `"}`)
+	got, err = offloadOutput(ctx, "m-2", "r-1", 0, large)
+	if err != nil {
+		t.Fatalf("offloadOutput(large): %v", err)
+	}
+	var ptr claimcheck.OffloadedOutput
+	if err := json.Unmarshal(got, &ptr); err != nil {
+		t.Fatalf("offloaded output %s: %v", got, err)
+	}
+	want := claimcheck.PayloadRef{Bucket: "payloads", Key: "outputs/m-2.json", Bytes: len(large)}
+	if ptr.ID != "m-2" || ptr.RunID != "r-1" || ptr.OutputRef == nil || *ptr.OutputRef != want {
+		t.Fatalf("offloaded output = %s, want ref %+v", got, want)
+	}
+	if stored := fake.objects["/payloads/outputs/m-2.json"]; !bytes.Equal(stored, large) {
+		t.Fatalf("stored output = %s, want %s", stored, large)
+	}
+
+	// 扇出时每个分支只分得 1/fanout 的阈值：small 单独不转存，4 路扇出时转存。
+	got, err = offloadOutput(ctx, "m-1", "r-1", 4, small)
+	if err != nil || claimcheck.OutputRefOf(got) == nil {
+		t.Fatalf("offloadOutput(small, fanout=4) = %s, %v; want outputRef", got, err)
+	}
+
+	t.Setenv("PAYLOAD_BUCKET", "")
+	if _, err := offloadOutput(ctx, "m-3", "r-1", 0, large); err == nil || !strings.Contains(err.Error(), "PAYLOAD_BUCKET") {
+		t.Fatalf("offloadOutput without bucket error = %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+// 消息体解码（与 cmd/dispatcher/codec.go 对应）：SQS 消息属性 contentEncoding 为 gzip+base64 / zstd+base64 时，
+// 消息体是压缩后再 base64 编码的 JSON（SNS 的消息属性同名同义）；claim-check 指针中的 encoding 描述 S3 对象内容，规则相同。
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstd 解码器可并发复用（DecodeAll）。
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// payloadStats 记录消息体的编码方式、大小与编解码耗时，写入 callback Output。
+type payloadStats struct {
+	Encoding    string
+	RawBytes    int64
+	WireBytes   int64
+	EncodeNanos int64
+	DecodeNanos int64
+}
+
+// decodeBody 按 encoding 解码 data；encoding 为空时原样返回。
+func decodeBody(encoding string, data []byte) ([]byte, error) {
+	var codec string
+	switch encoding {
+	case "":
+		return data, nil
+	case "gzip+base64":
+		codec = "gzip"
+	case "zstd+base64":
+		codec = "zstd"
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	compressed := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(compressed, data)
+	if err != nil {
+		return nil, fmt.Errorf("base64: %w", err)
+	}
+	compressed = compressed[:n]
+
+	if codec == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		defer zr.Close()
+		raw, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return raw, nil
+	}
+	raw, err := zstdDecoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("zstd: %w", err)
+	}
+	return raw, nil
+}
+
+// decodeDeliveryBody 按消息属性解码消息体，并返回大小与耗时统计（rawBytes/encodeNanos 来自 Dispatcher 设置的消息属性）。
+func decodeDeliveryBody(d delivery) ([]byte, payloadStats, error) {
+	stats := payloadStats{
+		Encoding:    d.Attributes["contentEncoding"],
+		WireBytes:   int64(len(d.Body)),
+		RawBytes:    parseInt64OrZero(d.Attributes["rawBytes"]),
+		EncodeNanos: parseInt64OrZero(d.Attributes["encodeNanos"]),
+	}
+	start := time.Now()
+	raw, err := decodeBody(stats.Encoding, []byte(d.Body))
+	if err != nil {
+		return nil, stats, err
+	}
+	if stats.Encoding != "" {
+		stats.DecodeNanos = time.Since(start).Nanoseconds()
+	}
+	if stats.RawBytes == 0 {
+		stats.RawBytes = int64(len(raw))
+	}
+	return raw, stats, nil
+}
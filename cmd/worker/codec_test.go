@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var zstdEncoderForTest, _ = zstd.NewWriter(nil)
+
+// encodeForTest 按 Dispatcher 的规则（cmd/dispatcher/codec.go）压缩并 base64 编码。
+func encodeForTest(t *testing.T, encoding string, raw []byte) []byte {
+	t.Helper()
+	var compressed []byte
+	switch encoding {
+	case "gzip+base64":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(raw); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		compressed = buf.Bytes()
+	case "zstd+base64":
+		compressed = zstdEncoderForTest.EncodeAll(raw, nil)
+	default:
+		return raw
+	}
+	return []byte(base64.StdEncoding.EncodeToString(compressed))
+}
+
+func TestDecodeBodyRoundTrip(t *testing.T) {
+	raw := []byte(`{"id":"m-1","taskToken":"token","padding":"` + strings.Repeat("x", 10000) + `"}`)
+	for _, encoding := range []string{"", "gzip+base64", "zstd+base64"} {
+		wire := encodeForTest(t, encoding, raw)
+		got, err := decodeBody(encoding, wire)
+		if err != nil {
+			t.Fatalf("decodeBody(%q): %v", encoding, err)
+		}
+		if !bytes.Equal(got, raw) {
+			t.Fatalf("decodeBody(%q) returned %d bytes, want %d", encoding, len(got), len(raw))
+		}
+
+		d := delivery{Body: string(wire), Attributes: map[string]string{"contentEncoding": encoding, "encodeNanos": "1234"}}
+		got, stats, err := decodeDeliveryBody(d)
+		if err != nil || !bytes.Equal(got, raw) {
+			t.Fatalf("decodeDeliveryBody(%q) = %d bytes, %v", encoding, len(got), err)
+		}
+		// rawBytes 属性缺失时取解码后的大小。
+		if stats.Encoding != encoding || stats.WireBytes != int64(len(wire)) || stats.RawBytes != int64(len(raw)) || stats.EncodeNanos != 1234 {
+			t.Fatalf("decodeDeliveryBody(%q) stats = %+v", encoding, stats)
+		}
+	}
+}
+
+func TestDecodeBodyErrors(t *testing.T) {
+	gz := encodeForTest(t, "gzip+base64", []byte(`{"id":"m-1"}`))
+	cases := []struct {
+		name     string
+		encoding string
+		data     []byte
+		want     string
+	}{
+		{name: "unsupported", encoding: "br+base64", data: []byte("AAAA"), want: "unsupported content encoding"},
+		{name: "bad base64", encoding: "gzip+base64", data: []byte("not base64!"), want: "base64"},
+		{name: "not gzip", encoding: "gzip+base64", data: []byte(base64.StdEncoding.EncodeToString([]byte("plain"))), want: "gzip"},
+		{name: "truncated gzip", encoding: "gzip+base64", data: []byte(base64.StdEncoding.EncodeToString(mustDecodeBase64(t, gz)[:10])), want: "gzip"},
+		{name: "not zstd", encoding: "zstd+base64", data: []byte(base64.StdEncoding.EncodeToString([]byte("plain"))), want: "zstd"},
+		{name: "wrong codec", encoding: "zstd+base64", data: gz, want: "zstd"},
+	}
+	for _, tc := range cases {
+		if _, err := decodeBody(tc.encoding, tc.data); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("decodeBody(%s) error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func mustDecodeBase64(t *testing.T, b []byte) []byte {
+	t.Helper()
+	out, err := base64.StdEncoding.DecodeString(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+package main
+
+// 长任务支持：Worker 执行耗时较长的工作时，按固定间隔
+//   - SendTaskHeartbeat：配合 Dispatch 任务的 HeartbeatSeconds，区分“仍在处理”和“任务丢失”；
+//   - ChangeMessageVisibility：延长消息可见性超时，避免处理中的消息被 SQS 重新投递。
+// 心跳发现 token 已失效（执行被中止/超时）时取消工作，调用方据此跳过回调。
+// 批内排队等待处理的投递同样保持心跳（heartbeatWhileWaiting），心跳超时只需覆盖 SQS 延迟、批处理窗口与冷启动。
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"testsqs/internal/env"
+)
+
+// errTaskTokenClosed 表示心跳期间发现 task token 已失效，工作被取消。
+var errTaskTokenClosed = errors.New("task token no longer valid")
+
+// runWithHeartbeat 执行 work，期间每隔 WORKER_HEARTBEAT_INTERVAL_MS（默认 5000）发送心跳并延长消息可见性
+// （WORKER_VISIBILITY_EXTENSION_SECONDS，默认 30）。返回 work 的错误与发送成功的心跳次数；返回前等待心跳 goroutine 退出。
+// 非 SQS 投递（没有 ReceiptHandle）只发送心跳。
+func runWithHeartbeat(ctx context.Context, d delivery, taskToken string, work func(ctx context.Context) error) (int64, error) {
+	workCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var heartbeats atomic.Int64
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		// 开始工作时立即发送一次心跳：重置 Dispatch 任务的心跳计时（此前经历了 SQS 等待与可能的冷启动）。
+		heartbeatLoop(workCtx, d, taskToken, done, &heartbeats, func() { cancel(errTaskTokenClosed) })
+	}()
+
+	err := work(workCtx)
+	close(done)
+	<-stopped
+	if cause := context.Cause(workCtx); errors.Is(cause, errTaskTokenClosed) {
+		return heartbeats.Load(), errTaskTokenClosed
+	}
+	return heartbeats.Load(), err
+}
+
+// heartbeatWhileWaiting 为批内暂时不能开始处理的投递（等待 WORKER_CONCURRENCY 槽位，或排在同组前序投递之后）保持心跳：
+// 立即发送一次，之后按 runWithHeartbeat 的间隔发送，直到调用返回的 stop（等待心跳 goroutine 退出，可重复调用）。
+// 否则 Dispatch 任务的心跳计时在等待期间照常流逝，排队较久的投递会在开始工作前就以 States.HeartbeatTimeout 失败。
+// 消息体无法解析或没有 taskToken 时不发送，留给 processDelivery 处理。
+func heartbeatWhileWaiting(ctx context.Context, d delivery) (stop func()) {
+	raw, _, err := decodeDeliveryBody(d)
+	if err != nil {
+		return func() {}
+	}
+	var body msgBody
+	if err := json.Unmarshal(raw, &body); err != nil || body.TaskToken == "" {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	var heartbeats atomic.Int64
+	go func() {
+		defer close(stopped)
+		heartbeatLoop(ctx, d, body.TaskToken, done, &heartbeats, func() {
+			log.Printf("task token no longer valid while waiting id=%s messageId=%s", body.ID, d.MessageID)
+		})
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// heartbeatLoop 立即发送一次心跳（并延长消息可见性），之后每隔 WORKER_HEARTBEAT_INTERVAL_MS 发送，直到 done 关闭或 ctx 结束。
+// 发现 task token 已失效时调用 onClosed 并退出。
+func heartbeatLoop(ctx context.Context, d delivery, taskToken string, done <-chan struct{}, heartbeats *atomic.Int64, onClosed func()) {
+	interval := time.Duration(env.Int("WORKER_HEARTBEAT_INTERVAL_MS", 5000)) * time.Millisecond
+	extension := env.Int("WORKER_VISIBILITY_EXTENSION_SECONDS", 30)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+
+		if taskToken != "" {
+			_, err := sfnClient.SendTaskHeartbeat(ctx, &sfn.SendTaskHeartbeatInput{TaskToken: aws.String(taskToken)})
+			switch {
+			case err == nil:
+				heartbeats.Add(1)
+			case isTaskTokenClosed(err):
+				onClosed()
+				return
+			default:
+				log.Printf("send task heartbeat failed messageId=%s: %v", d.MessageID, err)
+			}
+		}
+		if d.QueueURL != "" && d.ReceiptHandle != "" {
+			_, err := sqsClient.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(d.QueueURL),
+				ReceiptHandle:     aws.String(d.ReceiptHandle),
+				VisibilityTimeout: int32(extension),
+			})
+			if err != nil {
+				log.Printf("change message visibility failed messageId=%s: %v", d.MessageID, err)
+			}
+		}
+	}
+}
+
+// simulateWork 模拟耗时 d 的工作；ctx 取消时提前返回。
+func simulateWork(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("simulated work interrupted: %w", context.Cause(ctx))
+	}
+}
+
+// queueURLFromArn 由队列 ARN 推导 QueueUrl：
+// arn:aws:sqs:region:account:queueName -> https://sqs.region.amazonaws.com/account/queueName
+func queueURLFromArn(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[2] != "sqs" {
+		return ""
+	}
+	return fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", parts[3], parts[4], parts[5])
+}
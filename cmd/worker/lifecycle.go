@@ -0,0 +1,171 @@
+package main
+
+// 任务条目生命周期（TestTable，id=消息 id）：
+//
+//	pending（Dispatcher 发送前创建）-> processing（Worker 接收）-> succeeded / failed（Worker 回调后）
+//
+// 每次状态变化都记录时间戳，并刷新 TTL 属性 expiresAt（epoch 秒，ITEM_TTL_SECONDS，默认 7 天）。
+// 条件更新失败且条目已越过 pending，视为 SQS 重复投递，计入 duplicateDeliveries。
+//
+// 幂等：processing 状态带租约 leaseUntilUnixNano（取 Worker 调用的截止时间）。租约内的重复投递被跳过；
+// 租约过期说明持有者已随调用超时/崩溃退出，下一次投递可以接管（leaseTakeover），保证每条消息恰好回调一次。
+// 投递返回可重试的错误（交由 SQS 重投）前释放租约（releaseLease：processing -> pending），
+// 否则租约比队列的 VisibilityTimeout 长，重投会落在租约内被当作重复投递删除，回调再也不会发出。
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"testsqs/internal/env"
+	"testsqs/internal/tasktable"
+)
+
+const (
+	statusPending    = "pending"
+	statusProcessing = "processing"
+	statusSucceeded  = "succeeded"
+	statusFailed     = "failed"
+)
+
+// 回调结果（条目的 callback 属性）。
+const (
+	callbackSent        = "sent"
+	callbackTokenClosed = "token_closed"
+)
+
+// taskOutcome 是写入终态条目的结果。
+type taskOutcome struct {
+	Status    string // succeeded / failed
+	Callback  string // sent / token_closed；为空时不记录
+	ErrorCode string
+	Cause     string
+}
+
+// processingLeaseUntil 返回 processing 租约的到期时间：调用截止时间后再留 1s 余量；
+// ctx 没有截止时间时取 WORKER_LEASE_SECONDS（默认 900，即 Lambda 最长超时）。
+func processingLeaseUntil(ctx context.Context) int64 {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline.Add(time.Second).UnixNano()
+	}
+	return time.Now().Add(time.Duration(env.Int("WORKER_LEASE_SECONDS", 900)) * time.Second).UnixNano()
+}
+
+// isDuplicateStatus 判断条目状态是否说明消息已被（或正被）另一次投递处理。
+func isDuplicateStatus(status string) bool {
+	switch status {
+	case statusProcessing, statusSucceeded, statusFailed:
+		return true
+	}
+	return false
+}
+
+// markTaskFinished 把条目推进到终态（succeeded/failed），记录完成时间、回调结果与错误信息；已处于终态的条目不会被覆盖。
+func markTaskFinished(ctx context.Context, tableName, id string, outcome taskOutcome) error {
+	names := map[string]string{
+		"#status":    "status",
+		"#finished":  "finishedUnixNano",
+		"#expiresAt": "expiresAt",
+	}
+	values := map[string]dynamodbtypes.AttributeValue{
+		":status":     &dynamodbtypes.AttributeValueMemberS{Value: outcome.Status},
+		":finished":   &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().UnixNano())},
+		":expiresAt":  &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", tasktable.ExpiresAt())},
+		":pending":    &dynamodbtypes.AttributeValueMemberS{Value: statusPending},
+		":processing": &dynamodbtypes.AttributeValueMemberS{Value: statusProcessing},
+	}
+	update := "SET #status = :status, #finished = :finished, #expiresAt = :expiresAt"
+	if outcome.Callback != "" {
+		names["#callback"] = "callback"
+		values[":callback"] = &dynamodbtypes.AttributeValueMemberS{Value: outcome.Callback}
+		update += ", #callback = :callback"
+	}
+	if outcome.ErrorCode != "" {
+		names["#errorCode"] = "errorCode"
+		values[":errorCode"] = &dynamodbtypes.AttributeValueMemberS{Value: outcome.ErrorCode}
+		update += ", #errorCode = :errorCode"
+	}
+	if outcome.Cause != "" {
+		names["#errorCause"] = "errorCause"
+		values[":errorCause"] = &dynamodbtypes.AttributeValueMemberS{Value: outcome.Cause}
+		update += ", #errorCause = :errorCause"
+	}
+	_, err := ddbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]dynamodbtypes.AttributeValue{
+			"id": &dynamodbtypes.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_not_exists(#status) OR #status IN (:pending, :processing)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+// leaseReleaseTimeout 是释放租约的超时：释放发生在出错之后，调用方的 ctx 往往已经截止或被取消。
+const leaseReleaseTimeout = 2 * time.Second
+
+// releaseLease 把本次投递持有的 processing 条目退回 pending 并清除租约，让 SQS 重投可以立即重新领取；
+// 条件要求租约仍是 leaseUntilUnixNano，已被其它投递接管或已进入终态的条目不受影响。
+// 使用脱离 ctx 取消的独立超时（leaseReleaseTimeout）：工作超时或被取消后仍要能释放租约。
+func releaseLease(ctx context.Context, tableName, id string, leaseUntilUnixNano int64) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), leaseReleaseTimeout)
+	defer cancel()
+	_, err := ddbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]dynamodbtypes.AttributeValue{
+			"id": &dynamodbtypes.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET #status = :pending, #released = :now REMOVE #leaseUntil"),
+		ConditionExpression: aws.String("#status = :processing AND #leaseUntil = :leaseUntil"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":     "status",
+			"#released":   "leaseReleasedUnixNano",
+			"#leaseUntil": "leaseUntilUnixNano",
+		},
+		ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{
+			":pending":    &dynamodbtypes.AttributeValueMemberS{Value: statusPending},
+			":processing": &dynamodbtypes.AttributeValueMemberS{Value: statusProcessing},
+			":leaseUntil": &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", leaseUntilUnixNano)},
+			":now":        &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().UnixNano())},
+		},
+	})
+	return err
+}
+
+// recordDuplicateDelivery 对条目的 duplicateDeliveries 计数加一。
+func recordDuplicateDelivery(ctx context.Context, tableName, id string) error {
+	_, err := ddbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]dynamodbtypes.AttributeValue{
+			"id": &dynamodbtypes.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:         aws.String("ADD #dup :one SET #lastDup = :now"),
+		ConditionExpression:      aws.String("attribute_exists(id)"),
+		ExpressionAttributeNames: map[string]string{"#dup": "duplicateDeliveries", "#lastDup": "lastDuplicateUnixNano"},
+		ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{
+			":one": &dynamodbtypes.AttributeValueMemberN{Value: "1"},
+			":now": &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().UnixNano())},
+		},
+	})
+	return err
+}
+
+func numberAttr(item map[string]dynamodbtypes.AttributeValue, name string) int64 {
+	if v, ok := item[name].(*dynamodbtypes.AttributeValueMemberN); ok {
+		return parseInt64OrZero(v.Value)
+	}
+	return 0
+}
+
+func stringAttr(item map[string]dynamodbtypes.AttributeValue, name string) string {
+	if v, ok := item[name].(*dynamodbtypes.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
@@ -1,8 +1,10 @@
 // Lambda #2 (Worker)
 //
 // 作用：由 SQS 触发消费请求消息，并回调 Step Functions（SendTaskSuccess/Failure）。
-// 触发方式：SQS Event Source Mapping（RequestQueue -> Lambda）。
+// 触发方式：SQS Event Source Mapping（RequestQueue -> Lambda），开启 ReportBatchItemFailures，逐条上报失败的 record；
+// 也可以由 SNS 订阅、EventBridge 规则、Dispatcher 直接异步调用或 Kinesis 事件源触发（transport=sns/sns-sqs/eventbridge/lambda/kinesis，见 transport.go）。
 // 输出：通过 callback Output（JSON）把各阶段时间戳传回上游（Test/ApiFunction）。
+// 失败：消息本身的问题通过 SendTaskFailure 上报结构化错误码（Worker.*）；没有 taskToken 的毒消息转入 DLQ（WORKER_DLQ_URL）。
 //
 // 对应 SAM 资源：template.yaml 中的 WorkerFunction
 package main
@@ -19,32 +21,78 @@ import (
 	"sync"
 	"time"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"testsqs/internal/claimcheck"
+	"testsqs/internal/tasktable"
 )
 
 type msgBody struct {
-	ID           string `json:"id"`
+	ID                string `json:"id"`
 	SendUnixNano      int64  `json:"sendUnixNano"`
 	SendStartUnixNano int64  `json:"sendStartUnixNano"`
 	RunID             string `json:"runId"`
 	TaskToken         string `json:"taskToken"`
+	// Reply：没有 taskToken 时的结果回传方式（Express workflow）；"ddb" 表示只写完成记录，由 Dispatcher 等待。
+	Reply string `json:"reply,omitempty"`
+	// WorkMs：模拟工作耗时（毫秒），0 表示不模拟。
+	WorkMs int64 `json:"workMs,omitempty"`
+	// TaskType / TaskParams：选择 Worker 处理器及其参数（见 processor.go）。
+	TaskType   string          `json:"taskType,omitempty"`
+	TaskParams json.RawMessage `json:"taskParams,omitempty"`
+	// Lane：Dispatcher 选择的路由通道（default/high/low/fifo 等）。
+	Lane string `json:"lane,omitempty"`
+	// Transport：Dispatcher 使用的传输方式（仅记录；实际以事件形态为准）。
+	Transport string `json:"transport,omitempty"`
+	// ContentMode：Padding 的内容模式（repeated/random/json）。
+	ContentMode string `json:"contentMode,omitempty"`
+	Padding     string `json:"padding,omitempty"`
+	// Fanout：扇出执行的分支总数（单任务为 0），用于按分支均分 Output 转存阈值。
+	Fanout int `json:"fanout,omitempty"`
+	// PayloadRef：Dispatcher 转存到 S3 的完整消息体（claim-check，见 claimcheck.go）。
+	PayloadRef *claimcheck.PayloadRef `json:"payloadRef,omitempty"`
+}
+
+// Worker 上报的结构化错误码：作为 SendTaskFailure 的 Error 字段，执行失败时由 API 透传为 errorCode。
+const (
+	errInvalidMessage = "Worker.InvalidMessage"
+	errDdbConflict    = "Worker.DdbConflict" // 条目状态既不是 pending，也不是重复投递可解释的状态
+	errInternal       = "Worker.Internal"
+	errProcessor      = "Worker.ProcessorError"
+)
+
+// taskError 表示消息本身导致的处理失败（重试无意义），由 handleTaskError 上报而不是让 SQS 重投。
+type taskError struct {
+	Code  string
+	Cause string
+}
+
+func (e *taskError) Error() string {
+	return e.Code + ": " + e.Cause
 }
 
 type callbackOutput struct {
 	ID        string `json:"id"`
 	RunID     string `json:"runId"`
 	QueueName string `json:"queueName"`
+	// Lane：消息所在的路由通道（来自消息体；旧消息为空时取 default）。
+	Lane string `json:"lane,omitempty"`
+	// Transport：消息到达 Worker 的传输方式（sqs/sns/sns-sqs/eventbridge/lambda/kinesis）。
+	Transport string `json:"transport,omitempty"`
 	Region    string `json:"region"`
 
-	SendUnixNano      int64 `json:"sendUnixNano"`
-	SendStartUnixNano int64 `json:"sendStartUnixNano"`
-	ReceiveUnixNano   int64 `json:"receiveUnixNano"`
+	SendUnixNano       int64 `json:"sendUnixNano"`
+	SendStartUnixNano  int64 `json:"sendStartUnixNano"`
+	ReceiveUnixNano    int64 `json:"receiveUnixNano"`
 	WorkerDoneUnixNano int64 `json:"workerDoneUnixNano"`
 
 	// 回调请求发起的时间戳（注意：callback 的“结束时间”无法通过本次 Output 回传）。
@@ -53,6 +101,39 @@ type callbackOutput struct {
 	SqsSentTimestampMs         int64 `json:"sqsSentTimestampMs"`
 	SqsFirstReceiveTimestampMs int64 `json:"sqsFirstReceiveTimestampMs"`
 	SqsApproxReceiveCount      int64 `json:"sqsApproxReceiveCount"`
+	// SnsTimestampMs：SNS 接受发布请求的时间（毫秒，sns/sns-sqs）。
+	SnsTimestampMs int64 `json:"snsTimestampMs,omitempty"`
+	// EventTimeMs：EventBridge 事件的 time（毫秒，秒级精度）。
+	EventTimeMs int64 `json:"eventTimeMs,omitempty"`
+
+	// DuplicateDeliveries：回调前已被跳过的重复投递次数；LeaseTakeover：本次投递接管了租约过期的 processing 条目。
+	DuplicateDeliveries int64 `json:"duplicateDeliveries,omitempty"`
+	LeaseTakeover       bool  `json:"leaseTakeover,omitempty"`
+
+	// WorkMs：本次模拟工作的时长；Heartbeats：工作期间成功发送的 SendTaskHeartbeat 次数。
+	WorkMs     int64 `json:"workMs,omitempty"`
+	Heartbeats int64 `json:"heartbeats,omitempty"`
+
+	// FIFO 队列：消息组与 SQS 分配的序列号（标准队列为空；Kinesis 为记录的序列号）。
+	MessageGroupID string `json:"messageGroupId,omitempty"`
+	SequenceNumber string `json:"sequenceNumber,omitempty"`
+
+	// Kinesis：记录到达流的时间（毫秒，与 sqsSentTimestampMs 对应）与分片。
+	KinesisArrivalTimestampMs int64  `json:"kinesisArrivalTimestampMs,omitempty"`
+	ShardID                   string `json:"shardId,omitempty"`
+
+	// 消息体大小与编码：RawBytes 为编码前的 JSON 大小，WireBytes 为实际经过 SQS（或 S3）的大小；
+	// Encoding 为压缩方式（例如 gzip+base64），EncodeNanos/DecodeNanos 为 Dispatcher 编码与 Worker 解码耗时。
+	ContentMode string `json:"contentMode,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+	RawBytes    int64  `json:"rawBytes,omitempty"`
+	WireBytes   int64  `json:"wireBytes,omitempty"`
+	EncodeNanos int64  `json:"encodeNanos,omitempty"`
+	DecodeNanos int64  `json:"decodeNanos,omitempty"`
+
+	// TaskType：实际执行的处理器；Result：处理器返回的字段。
+	TaskType string         `json:"taskType,omitempty"`
+	Result   map[string]any `json:"result,omitempty"`
 }
 
 var (
@@ -61,6 +142,8 @@ var (
 
 	sfnClient *sfn.Client
 	ddbClient *dynamodb.Client
+	sqsClient *sqs.Client
+	s3Client  *s3.Client
 	region    string
 )
 
@@ -74,80 +157,293 @@ func initAWS() {
 		region = cfg.Region
 		sfnClient = sfn.NewFromConfig(cfg)
 		ddbClient = dynamodb.NewFromConfig(cfg)
+		sqsClient = sqs.NewFromConfig(cfg)
+		s3Client = claimcheck.NewS3Client(cfg)
 	})
 }
 
-func handler(ctx context.Context, event events.SQSEvent) error {
-	if initErr != nil {
-		return initErr
+// processDelivery 处理单条消息。返回 error 表示需要重投（SQS 重投或 Lambda 异步重试）；
+// 消息本身的问题（taskError）在这里被消化：有 taskToken 时 SendTaskFailure，没有时转入 DLQ。
+func processDelivery(ctx context.Context, tableName string, d delivery) (retErr error) {
+	queueName := d.Source
+
+	var body msgBody
+	rawBody, stats, err := decodeDeliveryBody(d)
+	if err != nil {
+		return handleTaskError(ctx, tableName, d, body, &taskError{Code: errInvalidMessage, Cause: fmt.Sprintf("decode message body: %v", err)})
+	}
+	if err := json.Unmarshal(rawBody, &body); err != nil {
+		return handleTaskError(ctx, tableName, d, body, &taskError{Code: errInvalidMessage, Cause: fmt.Sprintf("unmarshal message body: %v", err)})
 	}
-	tableName := strings.TrimSpace(os.Getenv("TABLE_NAME"))
-	if tableName == "" {
-		return errors.New("missing env TABLE_NAME")
+	if strings.TrimSpace(body.ID) == "" {
+		return handleTaskError(ctx, tableName, d, body, &taskError{Code: errInvalidMessage, Cause: "missing id in message body"})
 	}
+	if strings.TrimSpace(body.TaskToken) == "" && body.Reply != replyDdb {
+		return handleTaskError(ctx, tableName, d, body, &taskError{Code: errInvalidMessage, Cause: "missing taskToken in message body"})
+	}
+
+	// receiveUnixNano：Worker 实际接收到消息并准备落库的时间戳。
+	receiveUnixNano := time.Now().UnixNano()
 
-	for _, record := range event.Records {
-		// 每条 record 对应一条 SQS message。
-		queueName := queueNameFromArn(record.EventSourceARN)
+	sqsApproxReceiveCount := d.ReceiveCount
 
-		var body msgBody
-		if err := json.Unmarshal([]byte(record.Body), &body); err != nil {
-			return fmt.Errorf("unmarshal message body: %w", err)
+	// DynamoDB 条件更新（幂等键为消息 id）：pending -> processing，或接管租约过期的 processing。
+	// 条目处于租约内的 processing 或已是 succeeded/failed 说明是 SQS 重复投递：计入 duplicateDeliveries 后跳过，由持有租约的投递负责回调。
+	leaseUntil := processingLeaseUntil(ctx)
+	prevItem, err := performConditionalUpdate(ctx, tableName, body.ID, receiveUnixNano, sqsApproxReceiveCount, leaseUntil)
+	if err != nil {
+		var conflict *dynamodbtypes.ConditionalCheckFailedException
+		if errors.As(err, &conflict) {
+			prev := stringAttr(conflict.Item, "status")
+			if isDuplicateStatus(prev) {
+				if err := recordDuplicateDelivery(ctx, tableName, body.ID); err != nil {
+					log.Printf("record duplicate delivery failed id=%s: %v", body.ID, err)
+				}
+				log.Printf("duplicate delivery id=%s messageId=%s status=%s receiveCount=%d", body.ID, d.MessageID, prev, sqsApproxReceiveCount)
+				return nil
+			}
+			return handleTaskError(ctx, tableName, d, body, &taskError{Code: errDdbConflict, Cause: fmt.Sprintf("item id=%s has unexpected status %q", body.ID, prev)})
 		}
-		if strings.TrimSpace(body.ID) == "" {
-			return errors.New("missing id in message body")
+		// 节流、超时等 DynamoDB 瞬时错误与消息本身无关：返回错误交由 SQS 重投，而不是让执行失败。
+		return fmt.Errorf("conditional update id=%s: %w", body.ID, err)
+	}
+	// 之后返回的错误都会让 SQS 重投：先释放租约，否则重投落在租约内会被当作重复投递删除。
+	defer func() {
+		if retErr == nil {
+			return
 		}
-		if strings.TrimSpace(body.TaskToken) == "" {
-			return errors.New("missing taskToken in message body")
+		if err := releaseLease(ctx, tableName, body.ID, leaseUntil); err != nil {
+			log.Printf("release lease failed id=%s: %v", body.ID, err)
 		}
+	}()
+	duplicateDeliveries := numberAttr(prevItem, "duplicateDeliveries")
+	leaseTakeover := stringAttr(prevItem, "status") == statusProcessing
+	if leaseTakeover {
+		log.Printf("lease takeover id=%s messageId=%s receiveCount=%d", body.ID, d.MessageID, sqsApproxReceiveCount)
+	}
 
-		// receiveUnixNano：Worker 实际接收到消息并准备落库的时间戳。
-		receiveUnixNano := time.Now().UnixNano()
-
-		// SQS 属性时间戳（毫秒）
-		sqsSentTimestampMs := parseInt64OrZero(record.Attributes["SentTimestamp"])
-		sqsFirstReceiveTimestampMs := parseInt64OrZero(record.Attributes["ApproximateFirstReceiveTimestamp"])
-		sqsApproxReceiveCount := parseInt64OrZero(record.Attributes["ApproximateReceiveCount"])
-
-		// DynamoDB 条件更新：用于演示“只有当 status 不存在或为 pending 才更新”。
-		if err := performConditionalUpdate(ctx, tableName, body.ID, receiveUnixNano); err != nil {
-			// 条件不满足或更新失败不阻断主流程：仍然返回计时结果。
-			log.Printf("ddb conditional update failed id=%s: %v", body.ID, err)
+	// claim-check：消息体在 S3 中时先取回完整内容（计入 workerMs）。
+	if body.PayloadRef != nil {
+		if err := resolvePayload(ctx, &body, &stats); err != nil {
+			var terr *taskError
+			if errors.As(err, &terr) {
+				return handleTaskError(ctx, tableName, d, body, terr)
+			}
+			return err
 		}
+	}
 
-		// Worker 输出：回调 Step Functions，解除 waitForTaskToken。
-		workerDoneUnixNano := time.Now().UnixNano()
-		callbackRequestUnixNano := time.Now().UnixNano()
-		outBytes, err := json.Marshal(callbackOutput{
-			ID:        body.ID,
-			RunID:     body.RunID,
-			QueueName: queueName,
-			Region:    region,
-			SendUnixNano:            body.SendUnixNano,
-			SendStartUnixNano:       body.SendStartUnixNano,
-			ReceiveUnixNano:         receiveUnixNano,
-			WorkerDoneUnixNano:      workerDoneUnixNano,
-			CallbackRequestUnixNano: callbackRequestUnixNano,
-			SqsSentTimestampMs:         sqsSentTimestampMs,
-			SqsFirstReceiveTimestampMs: sqsFirstReceiveTimestampMs,
-			SqsApproxReceiveCount:      sqsApproxReceiveCount,
+	// 执行任务：按 taskType 选择处理器；workMs 只取消息中的值（API 据此计算 Dispatch 任务的超时与心跳超时）。
+	// 有实际工作时发送心跳并延长消息可见性。
+	taskType, processor, err := lookupProcessor(body.TaskType)
+	if err != nil {
+		return handleTaskError(ctx, tableName, d, body, &taskError{Code: errInvalidMessage, Cause: err.Error()})
+	}
+	workMs := max(body.WorkMs, 0)
+	var heartbeats int64
+	var result map[string]any
+	if taskType != defaultTaskType || workMs > 0 {
+		task := Task{ID: body.ID, RunID: body.RunID, TableName: tableName, WorkMs: workMs, Params: body.TaskParams, Payload: body.Padding}
+		heartbeats, err = runWithHeartbeat(ctx, d, body.TaskToken, func(ctx context.Context) error {
+			var perr error
+			result, perr = processor.Process(ctx, task)
+			return perr
 		})
+		if errors.Is(err, errTaskTokenClosed) {
+			log.Printf("abort work id=%s queue=%s: task token no longer valid", body.ID, queueName)
+			if err := markTaskFinished(ctx, tableName, body.ID, taskOutcome{Status: statusFailed, Callback: callbackTokenClosed, Cause: "work aborted: task token no longer valid"}); err != nil {
+				log.Printf("mark task failed failed id=%s: %v", body.ID, err)
+			}
+			return nil
+		}
 		if err != nil {
-			return fmt.Errorf("marshal callback output: %w", err)
+			// 节流、超时等瞬时错误与消息本身无关：原样返回交由 SQS 重投（租约随之释放），而不是让执行失败。
+			if isRetryableError(err) {
+				return fmt.Errorf("%s processor: %w", taskType, err)
+			}
+			return handleTaskError(ctx, tableName, d, body, &taskError{Code: errProcessor, Cause: fmt.Sprintf("%s processor: %v", taskType, err)})
 		}
+	}
+
+	// Worker 输出：回调 Step Functions，解除 waitForTaskToken。
+	workerDoneUnixNano := time.Now().UnixNano()
+	callbackRequestUnixNano := time.Now().UnixNano()
+	outBytes, err := json.Marshal(callbackOutput{
+		ID:                         body.ID,
+		RunID:                      body.RunID,
+		QueueName:                  queueName,
+		Lane:                       laneOrDefault(body.Lane),
+		Transport:                  d.Transport,
+		Region:                     region,
+		SendUnixNano:               body.SendUnixNano,
+		SendStartUnixNano:          body.SendStartUnixNano,
+		ReceiveUnixNano:            receiveUnixNano,
+		WorkerDoneUnixNano:         workerDoneUnixNano,
+		CallbackRequestUnixNano:    callbackRequestUnixNano,
+		SqsSentTimestampMs:         d.SqsSentTimestampMs,
+		SqsFirstReceiveTimestampMs: d.SqsFirstReceiveTimestampMs,
+		SqsApproxReceiveCount:      sqsApproxReceiveCount,
+		SnsTimestampMs:             d.SnsTimestampMs,
+		EventTimeMs:                d.EventTimeMs,
+		DuplicateDeliveries:        duplicateDeliveries,
+		LeaseTakeover:              leaseTakeover,
+		MessageGroupID:             d.MessageGroupID,
+		SequenceNumber:             d.SequenceNumber,
+		KinesisArrivalTimestampMs:  d.KinesisArrivalTimestampMs,
+		ShardID:                    d.ShardID,
+		ContentMode:                body.ContentMode,
+		Encoding:                   stats.Encoding,
+		RawBytes:                   stats.RawBytes,
+		WireBytes:                  stats.WireBytes,
+		EncodeNanos:                stats.EncodeNanos,
+		DecodeNanos:                stats.DecodeNanos,
+		WorkMs:                     workMs,
+		Heartbeats:                 heartbeats,
+		TaskType:                   taskType,
+		Result:                     result,
+	})
+	if err != nil {
+		return handleTaskError(ctx, tableName, d, body, &taskError{Code: errInternal, Cause: fmt.Sprintf("marshal callback output: %v", err)})
+	}
+	// 超过 Step Functions 输出上限的 Output 转存 S3，回调只带 outputRef（扇出时按分支数均分上限）。
+	outBytes, err = offloadOutput(ctx, body.ID, body.RunID, body.Fanout, outBytes)
+	if err != nil {
+		return handleTaskError(ctx, tableName, d, body, &taskError{Code: errInternal, Cause: err.Error()})
+	}
+	if body.TaskToken != "" {
 		_, err = sfnClient.SendTaskSuccess(ctx, &sfn.SendTaskSuccessInput{
 			TaskToken: aws.String(body.TaskToken),
 			Output:    aws.String(string(outBytes)),
 		})
 		if err != nil {
+			// token 已失效（执行被中止/超时/已回调）：重试也不会成功，按成功消费该消息，避免 SQS 反复重投。
+			// 执行已不在等待结果，因此不写完成记录。
+			if isTaskTokenClosed(err) {
+				log.Printf("skip task success id=%s queue=%s: task token no longer valid: %v", body.ID, queueName, err)
+				if err := markTaskFinished(ctx, tableName, body.ID, taskOutcome{Status: statusSucceeded, Callback: callbackTokenClosed}); err != nil {
+					log.Printf("mark task succeeded failed id=%s: %v", body.ID, err)
+				}
+				return nil
+			}
 			return fmt.Errorf("send task success: %w", err)
 		}
 		log.Printf("sent task success id=%s queue=%s", body.ID, queueName)
 	}
 
+	// 完成记录：供 ApiFunction 的 ddb 完成通知模式、以及 Express workflow 下的 Dispatcher 等待；
+	// Express（reply=ddb）时这是唯一的结果回传通道，失败需要让 SQS 重投。
+	// 必须先于终态写入：条目一旦是 succeeded，重投会被当作重复投递跳过，完成记录就再也不会补写。
+	rec := completionRecord{RunID: body.RunID, MessageID: body.ID, Status: "SUCCEEDED", Output: string(outBytes)}
+	if err := putCompletionRecord(ctx, tableName, rec); err != nil {
+		if body.Reply == replyDdb {
+			return fmt.Errorf("put completion record: %w", err)
+		}
+		log.Printf("put completion record failed id=%s runId=%s: %v", body.ID, body.RunID, err)
+	}
+
+	// 任务条目：processing -> succeeded（审计用，失败不影响结果）。
+	if err := markTaskFinished(ctx, tableName, body.ID, taskOutcome{Status: statusSucceeded, Callback: callbackSent}); err != nil {
+		log.Printf("mark task succeeded failed id=%s: %v", body.ID, err)
+	}
 	return nil
 }
 
+// handleTaskError 上报一条消息的处理失败：
+//   - 有 taskToken：SendTaskFailure（Error=错误码，Cause=原因），执行快速失败，API 透传错误；
+//   - Express（reply=ddb）：写入 FAILED 完成记录，Dispatcher 据此快速失败；
+//   - 都没有（毒消息）：转入 DLQ，避免 SQS 无限重投。
+//
+// 失败结果送达后才把条目推进到 failed；送达失败时返回错误交由 SQS 重投，条目不能先进入终态，否则重投会被当作重复投递跳过。
+func handleTaskError(ctx context.Context, tableName string, d delivery, body msgBody, terr *taskError) error {
+	log.Printf("task failed id=%s messageId=%s code=%s: %s", body.ID, d.MessageID, terr.Code, terr.Cause)
+	if err := reportTaskError(ctx, tableName, d, body, terr); err != nil {
+		return err
+	}
+	if strings.TrimSpace(body.ID) != "" {
+		if err := markTaskFinished(ctx, tableName, body.ID, taskOutcome{Status: statusFailed, ErrorCode: terr.Code, Cause: terr.Cause}); err != nil {
+			log.Printf("mark task failed failed id=%s: %v", body.ID, err)
+		}
+	}
+	return nil
+}
+
+// reportTaskError 按 handleTaskError 的规则把失败结果送达上游。
+func reportTaskError(ctx context.Context, tableName string, d delivery, body msgBody, terr *taskError) error {
+	switch {
+	case strings.TrimSpace(body.TaskToken) != "":
+		_, err := sfnClient.SendTaskFailure(ctx, &sfn.SendTaskFailureInput{
+			TaskToken: aws.String(body.TaskToken),
+			Error:     aws.String(terr.Code),
+			Cause:     aws.String(terr.Cause),
+		})
+		if err != nil {
+			if isTaskTokenClosed(err) {
+				log.Printf("skip task failure id=%s: task token no longer valid: %v", body.ID, err)
+				return nil
+			}
+			return fmt.Errorf("send task failure: %w", err)
+		}
+		rec := completionRecord{RunID: body.RunID, MessageID: body.ID, Status: "FAILED", Error: terr.Code, Cause: terr.Cause}
+		if err := putCompletionRecord(ctx, tableName, rec); err != nil {
+			log.Printf("put completion record failed id=%s runId=%s: %v", body.ID, body.RunID, err)
+		}
+		return nil
+	case body.Reply == replyDdb && strings.TrimSpace(body.RunID) != "" && strings.TrimSpace(body.ID) != "":
+		rec := completionRecord{RunID: body.RunID, MessageID: body.ID, Status: "FAILED", Error: terr.Code, Cause: terr.Cause}
+		if err := putCompletionRecord(ctx, tableName, rec); err != nil {
+			return fmt.Errorf("put completion record: %w", err)
+		}
+		return nil
+	default:
+		return sendToDeadLetter(ctx, d, terr)
+	}
+}
+
+// sendToDeadLetter 把无法回调的毒消息原样转发到 WORKER_DLQ_URL（SNS 信封已解开），并附带错误码/原因作为消息属性。
+// 未配置 DLQ 时返回错误，交由 SQS 重投，最终由 RedrivePolicy 转入 DLQ。
+func sendToDeadLetter(ctx context.Context, d delivery, terr *taskError) error {
+	dlqURL := strings.TrimSpace(os.Getenv("WORKER_DLQ_URL"))
+	if dlqURL == "" {
+		return terr
+	}
+	attrs := map[string]sqstypes.MessageAttributeValue{
+		"errorCode":       {DataType: aws.String("String"), StringValue: aws.String(terr.Code)},
+		"errorCause":      {DataType: aws.String("String"), StringValue: aws.String(terr.Cause)},
+		"sourceMessageId": {DataType: aws.String("String"), StringValue: aws.String(d.MessageID)},
+		"sourceQueue":     {DataType: aws.String("String"), StringValue: aws.String(d.Source)},
+		"sourceTransport": {DataType: aws.String("String"), StringValue: aws.String(d.Transport)},
+	}
+	// 保留编码方式，DLQ 中的消息体仍可解码。
+	if enc := d.Attributes["contentEncoding"]; enc != "" {
+		attrs["contentEncoding"] = sqstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(enc)}
+	}
+	_, err := sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(dlqURL),
+		MessageBody:       aws.String(d.Body),
+		MessageAttributes: attrs,
+	})
+	if err != nil {
+		return fmt.Errorf("send to dlq: %w", err)
+	}
+	log.Printf("moved poison message messageId=%s to dlq code=%s", d.MessageID, terr.Code)
+	return nil
+}
+
+// isTaskTokenClosed 判断回调失败是否因为 task token 已不可用（执行已结束、任务超时或 token 无效）。
+func isTaskTokenClosed(err error) bool {
+	var invalidToken *sfntypes.InvalidToken
+	var taskNotExist *sfntypes.TaskDoesNotExist
+	var taskTimedOut *sfntypes.TaskTimedOut
+	return errors.As(err, &invalidToken) || errors.As(err, &taskNotExist) || errors.As(err, &taskTimedOut)
+}
+
+func laneOrDefault(lane string) string {
+	if strings.TrimSpace(lane) == "" {
+		return "default"
+	}
+	return lane
+}
+
 func queueNameFromArn(arn string) string {
 	// arn:aws:sqs:region:account:queueName
 	parts := strings.Split(arn, ":")
@@ -168,23 +464,77 @@ func parseInt64OrZero(s string) int64 {
 	return n
 }
 
-func performConditionalUpdate(ctx context.Context, tableName, id string, receiveUnixNano int64) error {
-	_, err := ddbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+// performConditionalUpdate 把任务条目从 pending 推进到 processing（兼容没有 pending 条目的旧消息：status 不存在时也允许），
+// 或接管租约已过期的 processing 条目；成功时返回更新前的条目。
+// 条件不满足时返回 *dynamodbtypes.ConditionalCheckFailedException，其 Item 为条目当前内容。
+func performConditionalUpdate(ctx context.Context, tableName, id string, receiveUnixNano, receiveCount, leaseUntilUnixNano int64) (map[string]dynamodbtypes.AttributeValue, error) {
+	out, err := ddbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]dynamodbtypes.AttributeValue{
 			"id": &dynamodbtypes.AttributeValueMemberS{Value: id},
 		},
-		UpdateExpression:    aws.String("SET #status = :processing, #receiveTime = :receiveTime"),
-		ConditionExpression: aws.String("attribute_not_exists(#status) OR #status = :pending"),
+		UpdateExpression:    aws.String("SET #status = :processing, #receiveTime = :receiveTime, #receiveCount = :receiveCount, #leaseUntil = :leaseUntil, #expiresAt = :expiresAt"),
+		ConditionExpression: aws.String("attribute_not_exists(#status) OR #status = :pending OR (#status = :processing AND #leaseUntil < :receiveTime)"),
 		ExpressionAttributeNames: map[string]string{
-			"#status":      "status",
-			"#receiveTime": "receiveUnixNano",
+			"#status":       "status",
+			"#receiveTime":  "receiveUnixNano",
+			"#receiveCount": "receiveCount",
+			"#leaseUntil":   "leaseUntilUnixNano",
+			"#expiresAt":    "expiresAt",
 		},
 		ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{
-			":processing":  &dynamodbtypes.AttributeValueMemberS{Value: "processing"},
-			":pending":     &dynamodbtypes.AttributeValueMemberS{Value: "pending"},
-			":receiveTime": &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", receiveUnixNano)},
+			":processing":   &dynamodbtypes.AttributeValueMemberS{Value: statusProcessing},
+			":pending":      &dynamodbtypes.AttributeValueMemberS{Value: statusPending},
+			":receiveTime":  &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", receiveUnixNano)},
+			":receiveCount": &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", receiveCount)},
+			":leaseUntil":   &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", leaseUntilUnixNano)},
+			":expiresAt":    &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", tasktable.ExpiresAt())},
 		},
+		ReturnValues:                        dynamodbtypes.ReturnValueAllOld,
+		ReturnValuesOnConditionCheckFailure: dynamodbtypes.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Attributes, nil
+}
+
+const replyDdb = "ddb"
+
+// completionRecord 是写入 DynamoDB 的完成记录（id=run#<runId>）。
+type completionRecord struct {
+	RunID     string
+	MessageID string
+	Status    string // SUCCEEDED / FAILED
+	Output    string
+	Error     string
+	Cause     string
+}
+
+// putCompletionRecord 写入（覆盖）本次 run 的完成记录，completedUnixNano 取写入时刻（回调已确认）。
+func putCompletionRecord(ctx context.Context, tableName string, rec completionRecord) error {
+	if strings.TrimSpace(rec.RunID) == "" {
+		return nil
+	}
+	item := map[string]dynamodbtypes.AttributeValue{
+		"id":                &dynamodbtypes.AttributeValueMemberS{Value: tasktable.CompletionRecordKey(rec.RunID)},
+		"status":            &dynamodbtypes.AttributeValueMemberS{Value: rec.Status},
+		"messageId":         &dynamodbtypes.AttributeValueMemberS{Value: rec.MessageID},
+		"completedUnixNano": &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().UnixNano())},
+		"expiresAt":         &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", tasktable.ExpiresAt())},
+	}
+	if rec.Output != "" {
+		item["output"] = &dynamodbtypes.AttributeValueMemberS{Value: rec.Output}
+	}
+	if rec.Error != "" {
+		item["error"] = &dynamodbtypes.AttributeValueMemberS{Value: rec.Error}
+	}
+	if rec.Cause != "" {
+		item["cause"] = &dynamodbtypes.AttributeValueMemberS{Value: rec.Cause}
+	}
+	_, err := ddbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      item,
 	})
 	return err
 }
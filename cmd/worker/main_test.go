@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+)
+
+func TestIsTaskTokenClosed(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "invalid token", err: &sfntypes.InvalidToken{}, want: true},
+		{name: "task does not exist", err: &sfntypes.TaskDoesNotExist{}, want: true},
+		{name: "task timed out", err: &sfntypes.TaskTimedOut{}, want: true},
+		{name: "wrapped", err: fmt.Errorf("send task success: %w", &sfntypes.TaskTimedOut{}), want: true},
+		{name: "invalid output", err: &sfntypes.InvalidOutput{}, want: false},
+		{name: "plain error", err: errors.New("connection reset"), want: false},
+		{name: "nil", err: nil, want: false},
+	}
+	for _, tc := range cases {
+		if got := isTaskTokenClosed(tc.err); got != tc.want {
+			t.Errorf("isTaskTokenClosed(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// 无法回调（没有 taskToken）的毒消息在未配置 WORKER_DLQ_URL 时返回 taskError，交由 SQS 重投并最终由 RedrivePolicy 转入 DLQ；
+// 这些分支在访问 DynamoDB 之前返回，不需要 AWS。
+func TestProcessDeliveryPoisonWithoutDLQ(t *testing.T) {
+	t.Setenv("WORKER_DLQ_URL", "")
+	cases := []struct {
+		name      string
+		body      string
+		attrs     map[string]string
+		wantCause string
+	}{
+		{name: "not json", body: "not json", wantCause: "unmarshal message body"},
+		{name: "missing id", body: `{"runId":"r-1"}`, wantCause: "missing id"},
+		{name: "missing task token", body: `{"id":"m-1","runId":"r-1"}`, wantCause: "missing taskToken"},
+		{name: "unsupported encoding", body: "eJwLSS0uAQAEXQHB", attrs: map[string]string{"contentEncoding": "br+base64"}, wantCause: "decode message body"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			attrs := tc.attrs
+			if attrs == nil {
+				attrs = map[string]string{}
+			}
+			d := delivery{Transport: transportSQS, Source: "queue", MessageID: "msg-1", Body: tc.body, Attributes: attrs}
+			err := processDelivery(context.Background(), "tasks", d)
+			var terr *taskError
+			if !errors.As(err, &terr) || terr.Code != errInvalidMessage || !strings.Contains(terr.Cause, tc.wantCause) {
+				t.Fatalf("processDelivery error = %v, want %s containing %q", err, errInvalidMessage, tc.wantCause)
+			}
+		})
+	}
+}
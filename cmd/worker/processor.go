@@ -0,0 +1,209 @@
+package main
+
+// 任务处理器（Processor）：Worker 的“工作”部分，按消息中的 taskType 选择。
+// 处理器返回的字段写入 callbackOutput.result，便于在测试结果中区分不同工作负载的耗时。
+//
+// 内置处理器：
+//   - sleep（默认）：休眠 workMs 毫秒；
+//   - cpu：持续做 sha256 运算 workMs 毫秒（或固定 iterations 次）；
+//   - ddb：对 DynamoDB 执行 reads 次 GetItem 与 writes 次 UpdateItem；
+//   - echo：把消息中的 padding 原样回传。
+//
+// 处理器返回的错误默认按 Worker.ProcessorError 上报（执行失败）；属于 AWS SDK 瞬时错误（isRetryableError）时
+// 由 processDelivery 原样返回，交给 SQS 重投。
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"testsqs/internal/tasktable"
+)
+
+const defaultTaskType = "sleep"
+
+// Task 是处理器的输入。
+type Task struct {
+	ID        string
+	RunID     string
+	TableName string
+	WorkMs    int64
+	Params    json.RawMessage
+	Payload   string
+}
+
+// Processor 执行一次任务并返回要写入 callbackOutput.result 的字段。
+// 实现需要响应 ctx 取消（心跳发现 token 失效时会取消）。
+type Processor interface {
+	Process(ctx context.Context, task Task) (map[string]any, error)
+}
+
+// ProcessorFunc 让普通函数实现 Processor。
+type ProcessorFunc func(ctx context.Context, task Task) (map[string]any, error)
+
+func (f ProcessorFunc) Process(ctx context.Context, task Task) (map[string]any, error) {
+	return f(ctx, task)
+}
+
+var processors = map[string]Processor{}
+
+// registerProcessor 注册处理器；重复注册同名处理器会 panic（只应在 init 中调用）。
+func registerProcessor(name string, p Processor) {
+	if _, ok := processors[name]; ok {
+		panic(fmt.Sprintf("processor %q already registered", name))
+	}
+	processors[name] = p
+}
+
+// lookupProcessor 按 taskType 查找处理器，空值使用默认处理器。
+func lookupProcessor(taskType string) (string, Processor, error) {
+	name := strings.ToLower(strings.TrimSpace(taskType))
+	if name == "" {
+		name = defaultTaskType
+	}
+	p, ok := processors[name]
+	if !ok {
+		names := make([]string, 0, len(processors))
+		for n := range processors {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return name, nil, fmt.Errorf("unknown taskType %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return name, p, nil
+}
+
+func init() {
+	registerProcessor("sleep", ProcessorFunc(sleepProcessor))
+	registerProcessor("cpu", ProcessorFunc(cpuProcessor))
+	registerProcessor("ddb", ProcessorFunc(ddbProcessor))
+	registerProcessor("echo", ProcessorFunc(echoProcessor))
+}
+
+func sleepProcessor(ctx context.Context, task Task) (map[string]any, error) {
+	start := time.Now()
+	if err := simulateWork(ctx, time.Duration(task.WorkMs)*time.Millisecond); err != nil {
+		return nil, err
+	}
+	return map[string]any{"sleptMs": time.Since(start).Milliseconds()}, nil
+}
+
+type cpuParams struct {
+	// Iterations：固定运算次数；为 0 时按 workMs 计时运算。
+	Iterations int64 `json:"iterations,omitempty"`
+}
+
+func cpuProcessor(ctx context.Context, task Task) (map[string]any, error) {
+	var p cpuParams
+	if err := decodeParams(task.Params, &p); err != nil {
+		return nil, err
+	}
+	if p.Iterations <= 0 && task.WorkMs <= 0 {
+		return nil, fmt.Errorf("cpu processor requires workMs or params.iterations")
+	}
+
+	start := time.Now()
+	deadline := start.Add(time.Duration(task.WorkMs) * time.Millisecond)
+	sum := sha256.Sum256([]byte(task.ID))
+	var n int64
+	for {
+		if p.Iterations > 0 && n >= p.Iterations {
+			break
+		}
+		if p.Iterations <= 0 && !time.Now().Before(deadline) {
+			break
+		}
+		// 每 1024 次检查一次取消，避免频繁读 ctx。
+		if n%1024 == 0 && ctx.Err() != nil {
+			return nil, fmt.Errorf("cpu work interrupted: %w", context.Cause(ctx))
+		}
+		sum = sha256.Sum256(sum[:])
+		n++
+	}
+	return map[string]any{"iterations": n, "cpuMs": time.Since(start).Milliseconds(), "digest": fmt.Sprintf("%x", sum[:8])}, nil
+}
+
+type ddbParams struct {
+	Reads  int `json:"reads,omitempty"`
+	Writes int `json:"writes,omitempty"`
+}
+
+// ddbProcessor 对 id=work#<id>#<i> 的条目执行读写混合负载（默认 1 读 1 写）。
+func ddbProcessor(ctx context.Context, task Task) (map[string]any, error) {
+	p := ddbParams{Reads: 1, Writes: 1}
+	if err := decodeParams(task.Params, &p); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	var readMs, writeMs int64
+	for i := 0; i < p.Writes; i++ {
+		t := time.Now()
+		_, err := ddbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(task.TableName),
+			Key: map[string]dynamodbtypes.AttributeValue{
+				"id": &dynamodbtypes.AttributeValueMemberS{Value: fmt.Sprintf("work#%s#%d", task.ID, i)},
+			},
+			UpdateExpression:         aws.String("ADD #n :one SET #expiresAt = :expiresAt"),
+			ExpressionAttributeNames: map[string]string{"#n": "writes", "#expiresAt": "expiresAt"},
+			ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{
+				":one":       &dynamodbtypes.AttributeValueMemberN{Value: "1"},
+				":expiresAt": &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprintf("%d", tasktable.ExpiresAt())},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ddb write %d: %w", i, err)
+		}
+		writeMs += time.Since(t).Milliseconds()
+	}
+	for i := 0; i < p.Reads; i++ {
+		t := time.Now()
+		_, err := ddbClient.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(task.TableName),
+			Key: map[string]dynamodbtypes.AttributeValue{
+				"id": &dynamodbtypes.AttributeValueMemberS{Value: fmt.Sprintf("work#%s#%d", task.ID, i%max(p.Writes, 1))},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ddb read %d: %w", i, err)
+		}
+		readMs += time.Since(t).Milliseconds()
+	}
+	return map[string]any{"reads": p.Reads, "writes": p.Writes, "readMs": readMs, "writeMs": writeMs, "ddbMs": time.Since(start).Milliseconds()}, nil
+}
+
+func echoProcessor(_ context.Context, task Task) (map[string]any, error) {
+	out := map[string]any{"payloadBytes": len(task.Payload)}
+	if task.Payload != "" {
+		out["payload"] = task.Payload
+	}
+	if len(task.Params) > 0 {
+		out["params"] = task.Params
+	}
+	return out, nil
+}
+
+// isRetryableError 判断处理器错误是否为 AWS SDK 的瞬时错误（节流、超时、连接错误、5xx 等），
+// 分类与 SDK 默认重试策略一致；返回到这里时 SDK 自身的重试次数已经用完，但这类错误与消息本身无关，稍后重投通常能成功。
+func isRetryableError(err error) bool {
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+func decodeParams(raw json.RawMessage, v any) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid taskParams: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "provisioned throughput", err: &dynamodbtypes.ProvisionedThroughputExceededException{}, want: true},
+		{name: "request limit", err: &dynamodbtypes.RequestLimitExceeded{}, want: true},
+		{name: "throttling code", err: &smithy.GenericAPIError{Code: "ThrottlingException"}, want: true},
+		{name: "wrapped throttle", err: fmt.Errorf("ddb write 0: %w", &dynamodbtypes.ProvisionedThroughputExceededException{}), want: true},
+		{name: "resource not found", err: &dynamodbtypes.ResourceNotFoundException{}, want: false},
+		{name: "conditional check", err: &dynamodbtypes.ConditionalCheckFailedException{}, want: false},
+		{name: "validation", err: &smithy.GenericAPIError{Code: "ValidationException"}, want: false},
+		{name: "canceled", err: fmt.Errorf("cpu work interrupted: %w", context.Canceled), want: false},
+		{name: "invalid params", err: errors.New("invalid taskParams: unexpected end of JSON input"), want: false},
+	}
+	for _, tc := range cases {
+		if got := isRetryableError(tc.err); got != tc.want {
+			t.Errorf("isRetryableError(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+// 传输方式适配（与 cmd/dispatcher/transport.go 对应）：Worker 的同一个入口接收多种事件，
+// 各自转换为 delivery 后走相同的处理流程（processDelivery）：
+//   - SQS 事件：transport=sqs；消息体是 SNS 通知信封时（SNS→SQS 订阅，非 raw 投递）为 sns-sqs，解开信封取 Message 与 SNS Timestamp；
+//   - SNS 事件：transport=sns，Worker 直接订阅 topic（异步调用，处理失败时返回错误，由 Lambda 重试）；
+//   - EventBridge 事件（events.CloudWatchEvent）：transport=eventbridge，由自定义总线上的规则调用（同为异步调用）；
+//   - 消息体本身（或带编码消息体的 bodyEnvelope）：transport=lambda，Dispatcher 直接异步调用 Worker，不经过队列；
+//   - Kinesis 事件：transport=kinesis，记录数据为 bodyEnvelope；同一分片的记录按顺序串行处理，失败的记录通过 BatchItemFailures 上报（序列号）。
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+
+	"testsqs/internal/env"
+)
+
+const (
+	transportSQS         = "sqs"
+	transportSNS         = "sns"
+	transportSNSSQS      = "sns-sqs"
+	transportEventBridge = "eventbridge"
+	transportLambda      = "lambda"
+	transportKinesis     = "kinesis"
+)
+
+// delivery 是与传输方式无关的一次消息投递。
+type delivery struct {
+	Transport string
+	// Source / SourceARN：事件源（队列、topic）名称与 ARN；Source 写入 callback Output 的 queueName。
+	Source    string
+	SourceARN string
+	MessageID string
+	Body      string
+	// Attributes：消息属性（contentEncoding/rawBytes/encodeNanos 等）。
+	Attributes map[string]string
+
+	// SQS 属性时间戳（毫秒）与接收次数；非 SQS 投递为 0。
+	SqsSentTimestampMs         int64
+	SqsFirstReceiveTimestampMs int64
+	ReceiveCount               int64
+	// SnsTimestampMs：SNS 接受发布请求的时间（sns / sns-sqs）。
+	SnsTimestampMs int64
+	// EventTimeMs：EventBridge 事件的 time（只有秒级精度）。
+	EventTimeMs int64
+	// KinesisArrivalTimestampMs / ShardID：Kinesis 记录到达流的时间（毫秒）与所在分片。
+	KinesisArrivalTimestampMs int64
+	ShardID                   string
+
+	// FIFO 队列：消息组与序列号（Kinesis 为记录的序列号）。
+	MessageGroupID string
+	SequenceNumber string
+	// OrderingKey：需要按顺序处理的投递共享同一个键（FIFO 消息组、Kinesis 分片）；为空表示可与其它投递并发处理。
+	OrderingKey string
+
+	// QueueURL / ReceiptHandle：SQS 投递用于延长消息可见性；其它传输为空。
+	QueueURL      string
+	ReceiptHandle string
+}
+
+func handler(ctx context.Context, payload json.RawMessage) (any, error) {
+	if initErr != nil {
+		return nil, initErr
+	}
+	tableName := strings.TrimSpace(os.Getenv("TABLE_NAME"))
+	if tableName == "" {
+		return nil, errors.New("missing env TABLE_NAME")
+	}
+
+	source, enveloped, err := eventSource(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch source {
+	case "aws:sqs":
+		var event events.SQSEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("unmarshal sqs event: %w", err)
+		}
+		return handleSQSEvent(ctx, tableName, event), nil
+	case "aws:kinesis":
+		var event events.KinesisEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("unmarshal kinesis event: %w", err)
+		}
+		return handleKinesisEvent(ctx, tableName, event), nil
+	case "aws:sns":
+		var event events.SNSEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("unmarshal sns event: %w", err)
+		}
+		return nil, handleSNSEvent(ctx, tableName, event)
+	case "aws:events":
+		var event events.CloudWatchEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("unmarshal eventbridge event: %w", err)
+		}
+		return nil, processDelivery(ctx, tableName, fromCloudWatchEvent(event))
+	case "aws:lambda":
+		// 失败时返回错误，由 Lambda 异步调用重试。
+		return nil, processDelivery(ctx, tableName, fromInvokePayload(ctx, payload, enveloped))
+	default:
+		return nil, fmt.Errorf("unsupported event source %q", source)
+	}
+}
+
+// eventSource 识别 payload 的事件源（aws:sqs/aws:sns/aws:kinesis/aws:events/aws:lambda），无法识别时为空；
+// enveloped 表示 Lambda 直接调用的 payload 是 bodyEnvelope（有 body）而不是消息体本身。
+// SQS 与 Kinesis 事件的 record 字段为 eventSource，SNS 为 EventSource（encoding/json 匹配字段名时不区分大小写）；
+// EventBridge 事件没有 Records，以 detail-type 识别；Lambda 直接调用的 payload 是消息体（有 id）或 bodyEnvelope（有 body）。
+func eventSource(payload json.RawMessage) (string, bool, error) {
+	var probe struct {
+		Records []struct {
+			EventSource string `json:"eventSource"`
+		} `json:"Records"`
+		DetailType string          `json:"detail-type"`
+		ID         string          `json:"id"`
+		Body       json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return "", false, fmt.Errorf("unmarshal event: %w", err)
+	}
+	switch {
+	case len(probe.Records) > 0:
+		return probe.Records[0].EventSource, false, nil
+	case probe.DetailType != "":
+		return "aws:events", false, nil
+	case probe.ID != "" || len(probe.Body) > 0:
+		return "aws:lambda", len(probe.Body) > 0, nil
+	}
+	return "", false, nil
+}
+
+// handleSQSEvent 处理一批 SQS record，失败的 record 通过 BatchItemFailures 单独重投（需要 ReportBatchItemFailures）。
+func handleSQSEvent(ctx context.Context, tableName string, event events.SQSEvent) events.SQSEventResponse {
+	deliveries := make([]delivery, len(event.Records))
+	for i, record := range event.Records {
+		deliveries[i] = fromSQSMessage(record)
+	}
+	failed := processBatch(ctx, tableName, deliveries)
+
+	resp := events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{}}
+	for i, record := range event.Records {
+		if failed[i] {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+	return resp
+}
+
+// handleKinesisEvent 处理一批 Kinesis 记录，失败的记录以序列号上报（需要 ReportBatchItemFailures），
+// Lambda 从最早失败的序列号开始重试该分片（已成功的记录由幂等条目跳过）。
+func handleKinesisEvent(ctx context.Context, tableName string, event events.KinesisEvent) events.KinesisEventResponse {
+	deliveries := make([]delivery, len(event.Records))
+	for i, record := range event.Records {
+		deliveries[i] = fromKinesisRecord(record)
+	}
+	failed := processBatch(ctx, tableName, deliveries)
+
+	resp := events.KinesisEventResponse{BatchItemFailures: []events.KinesisBatchItemFailure{}}
+	for i, record := range event.Records {
+		if failed[i] {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.KinesisBatchItemFailure{ItemIdentifier: record.Kinesis.SequenceNumber})
+		}
+	}
+	return resp
+}
+
+// handleSNSEvent 处理 SNS 直接订阅的通知（每次调用通常只有一条）；失败时返回错误，由 Lambda 异步调用重试。
+func handleSNSEvent(ctx context.Context, tableName string, event events.SNSEvent) error {
+	deliveries := make([]delivery, len(event.Records))
+	for i, record := range event.Records {
+		deliveries[i] = fromSNSEntity(transportSNS, record.SNS)
+	}
+	var n int
+	for _, f := range processBatch(ctx, tableName, deliveries) {
+		if f {
+			n++
+		}
+	}
+	if n > 0 {
+		return fmt.Errorf("%d of %d sns records failed", n, len(deliveries))
+	}
+	return nil
+}
+
+// processBatch 处理一批投递，返回每条是否失败。彼此独立的投递并发处理（WORKER_CONCURRENCY，默认 1，即顺序处理）；
+// 有序投递（FIFO 消息组、Kinesis 分片）按顺序串行处理，某条失败后同组后续投递不再处理、一并标记失败，保证组内顺序。
+func processBatch(ctx context.Context, tableName string, deliveries []delivery) []bool {
+	concurrency := env.Int("WORKER_CONCURRENCY", 1)
+	failed := make([]bool, len(deliveries))
+	lanes := batchLanes(deliveries)
+
+	// 不能立即开始处理的投递（通道排在并发槽位之后，或排在同组前序投递之后）在等待期间保持心跳。
+	stops := make([]func(), len(deliveries))
+	for li, lane := range lanes {
+		for n, i := range lane {
+			if li >= concurrency || n > 0 {
+				stops[i] = heartbeatWhileWaiting(ctx, deliveries[i])
+			}
+		}
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, lane := range lanes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(lane []int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// 通道提前结束（前序投递失败）时，停止其余投递的等待心跳。
+			defer func() {
+				for _, i := range lane {
+					if stops[i] != nil {
+						stops[i]()
+					}
+				}
+			}()
+			for n, i := range lane {
+				if stops[i] != nil {
+					stops[i]()
+				}
+				d := deliveries[i]
+				if err := processDelivery(ctx, tableName, d); err != nil {
+					log.Printf("record failed transport=%s messageId=%s: %v", d.Transport, d.MessageID, err)
+					for _, j := range lane[n:] {
+						failed[j] = true
+					}
+					return
+				}
+			}
+		}(lane)
+	}
+	wg.Wait()
+	return failed
+}
+
+// batchLanes 把批内投递分成可并发处理的“通道”（下标）：一般每条一个通道；
+// OrderingKey 相同的投递按原顺序放在同一个通道。
+func batchLanes(deliveries []delivery) [][]int {
+	lanes := make([][]int, 0, len(deliveries))
+	groups := map[string]int{}
+	for i, d := range deliveries {
+		key := d.OrderingKey
+		if key == "" {
+			lanes = append(lanes, []int{i})
+			continue
+		}
+		if n, ok := groups[key]; ok {
+			lanes[n] = append(lanes[n], i)
+			continue
+		}
+		groups[key] = len(lanes)
+		lanes = append(lanes, []int{i})
+	}
+	return lanes
+}
+
+func fromSQSMessage(record events.SQSMessage) delivery {
+	d := delivery{
+		Transport:                  transportSQS,
+		Source:                     queueNameFromArn(record.EventSourceARN),
+		SourceARN:                  record.EventSourceARN,
+		MessageID:                  record.MessageId,
+		Body:                       record.Body,
+		Attributes:                 map[string]string{},
+		SqsSentTimestampMs:         parseInt64OrZero(record.Attributes["SentTimestamp"]),
+		SqsFirstReceiveTimestampMs: parseInt64OrZero(record.Attributes["ApproximateFirstReceiveTimestamp"]),
+		ReceiveCount:               parseInt64OrZero(record.Attributes["ApproximateReceiveCount"]),
+		MessageGroupID:             record.Attributes["MessageGroupId"],
+		SequenceNumber:             record.Attributes["SequenceNumber"],
+		QueueURL:                   queueURLFromArn(record.EventSourceARN),
+		ReceiptHandle:              record.ReceiptHandle,
+	}
+	// FIFO 队列（ARN 以 .fifo 结尾）：同一消息组按顺序处理。
+	if strings.HasSuffix(record.EventSourceARN, ".fifo") {
+		d.OrderingKey = record.EventSourceARN + "|" + d.MessageGroupID
+	}
+	for name, v := range record.MessageAttributes {
+		if v.StringValue != nil {
+			d.Attributes[name] = *v.StringValue
+		}
+	}
+	// SNS→SQS（非 raw 投递）：消息体是 SNS 通知信封，真正的消息体与属性在信封里。
+	if n, ok := unwrapSNSNotification(record.Body); ok {
+		inner := fromSNSEntity(transportSNSSQS, n)
+		d.Transport = inner.Transport
+		d.Body = inner.Body
+		d.Attributes = inner.Attributes
+		d.SnsTimestampMs = inner.SnsTimestampMs
+	}
+	return d
+}
+
+func fromSNSEntity(transport string, n events.SNSEntity) delivery {
+	d := delivery{
+		Transport:  transport,
+		Source:     topicNameFromArn(n.TopicArn),
+		SourceARN:  n.TopicArn,
+		MessageID:  n.MessageID,
+		Body:       n.Message,
+		Attributes: map[string]string{},
+	}
+	if !n.Timestamp.IsZero() {
+		d.SnsTimestampMs = n.Timestamp.UnixMilli()
+	}
+	// SNS 消息属性形如 {"Type": "String", "Value": "..."}。
+	for name, v := range n.MessageAttributes {
+		if attr, ok := v.(map[string]any); ok {
+			if s, ok := attr["Value"].(string); ok {
+				d.Attributes[name] = s
+			}
+		}
+	}
+	return d
+}
+
+// fromCloudWatchEvent 把 EventBridge 事件转换为 delivery：detail.body 为 JSON 对象（未编码）或 JSON 字符串（已编码）。
+func fromCloudWatchEvent(event events.CloudWatchEvent) delivery {
+	body, attrs := parseBodyEnvelope(event.Detail)
+	d := delivery{
+		Transport:  transportEventBridge,
+		Source:     event.Source,
+		MessageID:  event.ID,
+		Body:       body,
+		Attributes: attrs,
+	}
+	if !event.Time.IsZero() {
+		d.EventTimeMs = event.Time.UnixMilli()
+	}
+	return d
+}
+
+// fromKinesisRecord 把 Kinesis 记录转换为 delivery：eventID 形如 shardId-000000000000:<序列号>。
+func fromKinesisRecord(record events.KinesisEventRecord) delivery {
+	body, attrs := parseBodyEnvelope(record.Kinesis.Data)
+	shardID, _, _ := strings.Cut(record.EventID, ":")
+	d := delivery{
+		Transport:      transportKinesis,
+		Source:         streamNameFromArn(record.EventSourceArn),
+		SourceARN:      record.EventSourceArn,
+		MessageID:      record.EventID,
+		Body:           body,
+		Attributes:     attrs,
+		ShardID:        shardID,
+		SequenceNumber: record.Kinesis.SequenceNumber,
+		OrderingKey:    record.EventSourceArn + "|" + shardID,
+	}
+	if t := record.Kinesis.ApproximateArrivalTimestamp; !t.IsZero() {
+		// 以浮点秒表示，四舍五入到毫秒。
+		d.KinesisArrivalTimestampMs = t.Round(time.Millisecond).UnixMilli()
+	}
+	return d
+}
+
+func streamNameFromArn(arn string) string {
+	// arn:aws:kinesis:region:account:stream/streamName
+	_, name, _ := strings.Cut(arn, ":stream/")
+	return name
+}
+
+// fromInvokePayload 把直接异步调用的 payload 转换为 delivery；MessageID 取本次调用的 request id。
+// 没有传输层时间戳：sqsWaitMs 由 Dispatcher 的发送时间戳计算，即 Invoke 请求加异步调用排队的时间。
+func fromInvokePayload(ctx context.Context, payload json.RawMessage, enveloped bool) delivery {
+	d := delivery{
+		Transport:  transportLambda,
+		Source:     lambdacontext.FunctionName,
+		Body:       string(payload),
+		Attributes: map[string]string{},
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		d.SourceARN = lc.InvokedFunctionArn
+		d.MessageID = lc.AwsRequestID
+	}
+	if enveloped {
+		d.Body, d.Attributes = parseBodyEnvelope(payload)
+	}
+	return d
+}
+
+// parseBodyEnvelope 解析 bodyEnvelope（与 cmd/dispatcher/transport.go 中的同名结构保持一致）：
+// body 为 JSON 对象（未编码）或 JSON 字符串（已编码）。
+func parseBodyEnvelope(raw json.RawMessage) (string, map[string]string) {
+	var env struct {
+		Attributes map[string]string `json:"attributes"`
+		Body       json.RawMessage   `json:"body"`
+	}
+	_ = json.Unmarshal(raw, &env)
+	body := string(env.Body)
+	if len(env.Body) > 0 && env.Body[0] == '"' {
+		_ = json.Unmarshal(env.Body, &body)
+	}
+	if env.Attributes == nil {
+		env.Attributes = map[string]string{}
+	}
+	return body, env.Attributes
+}
+
+// unwrapSNSNotification 判断 SQS 消息体是否为 SNS 通知信封。
+func unwrapSNSNotification(body string) (events.SNSEntity, bool) {
+	if !strings.Contains(body, `"TopicArn"`) {
+		return events.SNSEntity{}, false
+	}
+	var n events.SNSEntity
+	if err := json.Unmarshal([]byte(body), &n); err != nil || n.Type != "Notification" || n.TopicArn == "" {
+		return events.SNSEntity{}, false
+	}
+	return n, true
+}
+
+func topicNameFromArn(arn string) string {
+	// arn:aws:sns:region:account:topicName
+	parts := strings.Split(arn, ":")
+	return parts[len(parts)-1]
+}
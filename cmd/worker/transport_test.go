@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const testQueueArn = "arn:aws:sqs:us-east-1:123456789012:worker-queue"
+
+// 毒消息在未配置 WORKER_DLQ_URL 时失败（见 TestProcessDeliveryPoisonWithoutDLQ），用来构造不需要 AWS 的失败投递。
+func TestHandleSQSEventReportsFailedRecords(t *testing.T) {
+	t.Setenv("WORKER_DLQ_URL", "")
+	t.Setenv("WORKER_CONCURRENCY", "2")
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "msg-1", EventSourceARN: testQueueArn, Body: "not json"},
+		{MessageId: "msg-2", EventSourceARN: testQueueArn, Body: `{"runId":"r-1"}`},
+		{MessageId: "msg-3", EventSourceARN: testQueueArn, Body: `{"id":"m-3"}`},
+	}}
+	resp := handleSQSEvent(context.Background(), "tasks", event)
+	var got []string
+	for _, f := range resp.BatchItemFailures {
+		got = append(got, f.ItemIdentifier)
+	}
+	if want := []string{"msg-1", "msg-2", "msg-3"}; !slices.Equal(got, want) {
+		t.Fatalf("batch item failures = %q, want %q", got, want)
+	}
+
+	// 没有失败的 record 时 batchItemFailures 序列化为 [] 而不是 null。
+	b, err := json.Marshal(handleSQSEvent(context.Background(), "tasks", events.SQSEvent{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"batchItemFailures":[]}` {
+		t.Fatalf("empty batch response = %s", b)
+	}
+}
+
+func TestBatchLanes(t *testing.T) {
+	cases := []struct {
+		name string
+		keys []string
+		want [][]int
+	}{
+		{name: "empty", keys: nil, want: [][]int{}},
+		{name: "unordered", keys: []string{"", "", ""}, want: [][]int{{0}, {1}, {2}}},
+		{name: "one group", keys: []string{"g1", "g1", "g1"}, want: [][]int{{0, 1, 2}}},
+		{name: "interleaved groups keep order", keys: []string{"g1", "g2", "g1", "", "g2", "g1"}, want: [][]int{{0, 2, 5}, {1, 4}, {3}}},
+	}
+	for _, tc := range cases {
+		deliveries := make([]delivery, len(tc.keys))
+		for i, k := range tc.keys {
+			deliveries[i] = delivery{OrderingKey: k}
+		}
+		got := batchLanes(deliveries)
+		if !slices.EqualFunc(got, tc.want, slices.Equal[[]int]) {
+			t.Errorf("batchLanes(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFromSQSMessageOrderingKey(t *testing.T) {
+	fifoArn := testQueueArn + ".fifo"
+	record := func(arn, group string) events.SQSMessage {
+		return events.SQSMessage{MessageId: "msg", EventSourceARN: arn, Body: `{}`, Attributes: map[string]string{"MessageGroupId": group}}
+	}
+	if d := fromSQSMessage(record(testQueueArn, "")); d.OrderingKey != "" {
+		t.Errorf("standard queue ordering key = %q, want empty", d.OrderingKey)
+	}
+	a, b := fromSQSMessage(record(fifoArn, "g1")), fromSQSMessage(record(fifoArn, "g2"))
+	if a.OrderingKey == "" || a.OrderingKey == b.OrderingKey || a.MessageGroupID != "g1" {
+		t.Errorf("fifo ordering keys = %q, %q", a.OrderingKey, b.OrderingKey)
+	}
+	if c := fromSQSMessage(record(fifoArn, "g1")); c.OrderingKey != a.OrderingKey {
+		t.Errorf("same message group has different ordering keys %q, %q", a.OrderingKey, c.OrderingKey)
+	}
+}
+
+// FIFO 消息组中前序消息失败后，同组后续消息不再处理、一并上报失败（SQS 按组内顺序重投）。
+// 后续消息是合法的 ddb 回复消息：如果被处理会访问 DynamoDB（测试中没有客户端），因此测试通过即说明它被跳过。
+func TestHandleSQSEventFIFOGroupStopsAfterFailure(t *testing.T) {
+	t.Setenv("WORKER_DLQ_URL", "")
+	t.Setenv("WORKER_CONCURRENCY", "4")
+	fifoArn := testQueueArn + ".fifo"
+	group := map[string]string{"MessageGroupId": "g1"}
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "msg-1", EventSourceARN: fifoArn, Attributes: group, Body: "not json"},
+		{MessageId: "msg-2", EventSourceARN: fifoArn, Attributes: group, Body: `{"id":"m-2","runId":"r-1","reply":"ddb"}`},
+		{MessageId: "msg-3", EventSourceARN: fifoArn, Attributes: group, Body: `{"id":"m-3","runId":"r-1","reply":"ddb"}`},
+	}}
+	resp := handleSQSEvent(context.Background(), "tasks", event)
+	var got []string
+	for _, f := range resp.BatchItemFailures {
+		got = append(got, f.ItemIdentifier)
+	}
+	if want := []string{"msg-1", "msg-2", "msg-3"}; !slices.Equal(got, want) {
+		t.Fatalf("batch item failures = %q, want %q", got, want)
+	}
+}
+
+func TestEventSource(t *testing.T) {
+	cases := []struct {
+		name          string
+		payload       string
+		want          string
+		wantEnveloped bool
+		wantErr       bool
+	}{
+		{name: "sqs", payload: `{"Records":[{"messageId":"m","eventSource":"aws:sqs","body":"{}"}]}`, want: "aws:sqs"},
+		{name: "sns", payload: `{"Records":[{"EventSource":"aws:sns","Sns":{"Message":"{}"}}]}`, want: "aws:sns"},
+		{name: "kinesis", payload: `{"Records":[{"eventSource":"aws:kinesis","kinesis":{"data":"e30="}}]}`, want: "aws:kinesis"},
+		{name: "eventbridge", payload: `{"id":"evt-1","detail-type":"TaskDispatched","source":"testsqs","detail":{"body":{}}}`, want: "aws:events"},
+		{name: "lambda body", payload: `{"id":"m-1","taskToken":"token"}`, want: "aws:lambda"},
+		{name: "lambda envelope", payload: `{"attributes":{"contentEncoding":"gzip+base64"},"body":"H4sI"}`, want: "aws:lambda", wantEnveloped: true},
+		{name: "empty records", payload: `{"Records":[]}`, want: ""},
+		{name: "unknown", payload: `{"foo":"bar"}`, want: ""},
+		{name: "not an object", payload: `[1,2]`, wantErr: true},
+		{name: "invalid json", payload: `{`, wantErr: true},
+	}
+	for _, tc := range cases {
+		got, enveloped, err := eventSource(json.RawMessage(tc.payload))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("eventSource(%s) error = %v, wantErr %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want || enveloped != tc.wantEnveloped {
+			t.Errorf("eventSource(%s) = %q, %v; want %q, %v", tc.name, got, enveloped, tc.want, tc.wantEnveloped)
+		}
+	}
+}
+
+func TestFromSQSMessageUnwrapsSNSNotification(t *testing.T) {
+	notification := `{"Type":"Notification","MessageId":"sns-1","TopicArn":"arn:aws:sns:us-east-1:123456789012:dispatch",` +
+		`"Message":"{\"id\":\"m-1\"}","Timestamp":"2026-10-16T12:00:00.123Z",` +
+		`"MessageAttributes":{"contentEncoding":{"Type":"String","Value":"gzip+base64"},"rawBytes":{"Type":"Number","Value":"42"}}}`
+	d := fromSQSMessage(events.SQSMessage{
+		MessageId:      "msg-1",
+		EventSourceARN: testQueueArn,
+		Body:           notification,
+		Attributes:     map[string]string{"SentTimestamp": "1792152000200", "ApproximateReceiveCount": "2"},
+	})
+	if d.Transport != transportSNSSQS || d.Body != `{"id":"m-1"}` || d.MessageID != "msg-1" || d.Source != "worker-queue" {
+		t.Fatalf("delivery = %+v", d)
+	}
+	if d.SnsTimestampMs != 1792152000123 || d.SqsSentTimestampMs != 1792152000200 || d.ReceiveCount != 2 {
+		t.Fatalf("timestamps sns=%d sqs=%d receiveCount=%d", d.SnsTimestampMs, d.SqsSentTimestampMs, d.ReceiveCount)
+	}
+	if d.Attributes["contentEncoding"] != "gzip+base64" || d.Attributes["rawBytes"] != "42" {
+		t.Fatalf("attributes = %v", d.Attributes)
+	}
+
+	// raw 投递或普通消息体不是信封，原样保留。
+	for _, body := range []string{`{"id":"m-1"}`, `{"id":"m-1","TopicArn":"x"}`, `not json "TopicArn"`} {
+		if d := fromSQSMessage(events.SQSMessage{EventSourceARN: testQueueArn, Body: body}); d.Transport != transportSQS || d.Body != body {
+			t.Errorf("fromSQSMessage(%s) = transport %s body %s", body, d.Transport, d.Body)
+		}
+	}
+}
+
+func TestParseBodyEnvelope(t *testing.T) {
+	cases := []struct {
+		name      string
+		raw       string
+		wantBody  string
+		wantAttrs map[string]string
+	}{
+		{name: "json object body", raw: `{"body":{"id":"m-1","n":1}}`, wantBody: `{"id":"m-1","n":1}`, wantAttrs: map[string]string{}},
+		{name: "encoded string body", raw: `{"attributes":{"contentEncoding":"zstd+base64","rawBytes":"12"},"body":"KLUv/QBY"}`,
+			wantBody: "KLUv/QBY", wantAttrs: map[string]string{"contentEncoding": "zstd+base64", "rawBytes": "12"}},
+		{name: "escaped string body", raw: `{"body":"{\"id\":\"m-1\"}"}`, wantBody: `{"id":"m-1"}`, wantAttrs: map[string]string{}},
+		{name: "missing body", raw: `{"attributes":{"rawBytes":"0"}}`, wantBody: "", wantAttrs: map[string]string{"rawBytes": "0"}},
+		{name: "invalid", raw: `not json`, wantBody: "", wantAttrs: map[string]string{}},
+	}
+	for _, tc := range cases {
+		body, attrs := parseBodyEnvelope(json.RawMessage(tc.raw))
+		if body != tc.wantBody || !maps.Equal(attrs, tc.wantAttrs) || attrs == nil {
+			t.Errorf("parseBodyEnvelope(%s) = %q, %v; want %q, %v", tc.name, body, attrs, tc.wantBody, tc.wantAttrs)
+		}
+	}
+}
+
+func TestFromInvokePayload(t *testing.T) {
+	ctx := context.Background()
+	plain := json.RawMessage(`{"id":"m-1","taskToken":"token"}`)
+	if d := fromInvokePayload(ctx, plain, false); d.Transport != transportLambda || d.Body != string(plain) || len(d.Attributes) != 0 {
+		t.Errorf("plain payload delivery = %+v", d)
+	}
+	enveloped := json.RawMessage(`{"attributes":{"contentEncoding":"gzip+base64"},"body":"H4sI"}`)
+	if d := fromInvokePayload(ctx, enveloped, true); d.Body != "H4sI" || d.Attributes["contentEncoding"] != "gzip+base64" {
+		t.Errorf("enveloped payload delivery = %+v", d)
+	}
+}
+
+func TestFromKinesisRecord(t *testing.T) {
+	const streamArn = "arn:aws:kinesis:us-east-1:123456789012:stream/dispatch"
+	record := func(shard, seq string) events.KinesisEventRecord {
+		r := events.KinesisEventRecord{EventID: shard + ":" + seq, EventSourceArn: streamArn}
+		r.Kinesis.SequenceNumber = seq
+		r.Kinesis.Data = []byte(`{"attributes":{"rawBytes":"12"},"body":{"id":"m-1"}}`)
+		r.Kinesis.ApproximateArrivalTimestamp = events.SecondsEpochTime{Time: time.UnixMilli(1792152000123).Add(400 * time.Microsecond)}
+		return r
+	}
+	d := fromKinesisRecord(record("shardId-000000000001", "4960"))
+	if d.Transport != transportKinesis || d.Source != "dispatch" || d.ShardID != "shardId-000000000001" || d.SequenceNumber != "4960" {
+		t.Fatalf("delivery = %+v", d)
+	}
+	if d.Body != `{"id":"m-1"}` || d.Attributes["rawBytes"] != "12" || d.KinesisArrivalTimestampMs != 1792152000123 {
+		t.Fatalf("body=%s attrs=%v arrival=%d", d.Body, d.Attributes, d.KinesisArrivalTimestampMs)
+	}
+	// 同一分片共用一个 OrderingKey（串行处理），不同分片可并发。
+	same, other := fromKinesisRecord(record("shardId-000000000001", "4961")), fromKinesisRecord(record("shardId-000000000002", "1"))
+	if d.OrderingKey == "" || same.OrderingKey != d.OrderingKey || other.OrderingKey == d.OrderingKey {
+		t.Fatalf("ordering keys = %q, %q, %q", d.OrderingKey, same.OrderingKey, other.OrderingKey)
+	}
+}
+
+// 分片内前序记录失败后，同分片后续记录一并以序列号上报失败；其它分片不受影响（这里同样是毒消息）。
+func TestHandleKinesisEventReportsSequenceNumbers(t *testing.T) {
+	t.Setenv("WORKER_DLQ_URL", "")
+	const streamArn = "arn:aws:kinesis:us-east-1:123456789012:stream/dispatch"
+	record := func(shard, seq, data string) events.KinesisEventRecord {
+		r := events.KinesisEventRecord{EventID: shard + ":" + seq, EventSourceArn: streamArn}
+		r.Kinesis.SequenceNumber = seq
+		r.Kinesis.Data = []byte(data)
+		return r
+	}
+	event := events.KinesisEvent{Records: []events.KinesisEventRecord{
+		record("shardId-1", "100", `{"body":"not json"}`),
+		record("shardId-2", "200", `{"body":{"runId":"r-1"}}`),
+		// 合法的 ddb 回复消息：被处理会访问 DynamoDB（测试中没有客户端），通过即说明它被跳过。
+		record("shardId-1", "101", `{"body":{"id":"m-3","runId":"r-1","reply":"ddb"}}`),
+	}}
+	resp := handleKinesisEvent(context.Background(), "tasks", event)
+	var got []string
+	for _, f := range resp.BatchItemFailures {
+		got = append(got, f.ItemIdentifier)
+	}
+	if want := []string{"100", "200", "101"}; !slices.Equal(got, want) {
+		t.Fatalf("batch item failures = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+// Package claimcheck 实现 Dispatcher、Worker 与 ApiFunction 共用的 S3 claim-check：
+//   - Dispatcher：超过阈值的消息体写入 PAYLOAD_BUCKET，队列中只放带 payloadRef 的指针消息；
+//   - Worker：按 payloadRef 取回消息体；超过阈值的 callback Output 同样转存，回调只带 outputRef；
+//   - ApiFunction：按 outputRef 取回完整 Output。
+//
+// S3_ENDPOINT_URL 可指向本地 S3 兼容服务（MinIO、LocalStack 等，使用 path-style 寻址）。
+package claimcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"testsqs/internal/env"
+)
+
+// DefaultThresholdBytes：SQS 消息体与 Step Functions 任务输出的上限都是 256KB（262144 字节），默认阈值给其它开销留余量。
+const DefaultThresholdBytes = 256000
+
+// PayloadRef 指向 S3 中的完整内容。
+type PayloadRef struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Bytes  int    `json:"bytes"`
+	// Encoding：对象内容的编码方式（例如 gzip+base64），为空表示未编码的 JSON。
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// OffloadedOutput 是 callback Output 被转存后实际回调的内容：保留 id/runId 便于关联。
+type OffloadedOutput struct {
+	ID        string      `json:"id"`
+	RunID     string      `json:"runId"`
+	OutputRef *PayloadRef `json:"outputRef"`
+}
+
+// NewS3Client 创建 S3 客户端；配置了 S3_ENDPOINT_URL 时改用该端点并启用 path-style。
+func NewS3Client(cfg aws.Config) *s3.Client {
+	endpoint := strings.TrimSpace(os.Getenv("S3_ENDPOINT_URL"))
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+}
+
+// ThresholdBytes 返回转存阈值（PAYLOAD_OFFLOAD_THRESHOLD_BYTES，默认 DefaultThresholdBytes）。
+func ThresholdBytes() int {
+	return env.Int("PAYLOAD_OFFLOAD_THRESHOLD_BYTES", DefaultThresholdBytes)
+}
+
+// OutputThresholdBytes 返回 callback Output 的转存阈值。扇出执行（fanout=N）的输出是 N 个分支 Output 组成的数组，
+// 整体同样受 Step Functions 256KB 上限约束，因此每个分支只分得 1/N 的阈值。
+func OutputThresholdBytes(fanout int) int {
+	if fanout > 1 {
+		return ThresholdBytes() / fanout
+	}
+	return ThresholdBytes()
+}
+
+// Offload 把 body 写入 PAYLOAD_BUCKET 的 key 并返回指针；encoding 为 body 的编码方式（为空表示未编码的 JSON）。
+func Offload(ctx context.Context, client *s3.Client, key string, body []byte, encoding string) (*PayloadRef, error) {
+	bucket := strings.TrimSpace(os.Getenv("PAYLOAD_BUCKET"))
+	if bucket == "" {
+		return nil, fmt.Errorf("%d bytes exceeds the offload threshold and PAYLOAD_BUCKET is not configured", len(body))
+	}
+	ref := &PayloadRef{Bucket: bucket, Key: key, Bytes: len(body), Encoding: encoding}
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(ref.Bucket),
+		Key:         aws.String(ref.Key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("put s3://%s/%s: %w", ref.Bucket, ref.Key, err)
+	}
+	return ref, nil
+}
+
+// Fetch 取回 ref 指向的对象内容（未按 ref.Encoding 解码）。对象不存在时返回的错误包装 *s3types.NoSuchKey。
+func Fetch(ctx context.Context, client *s3.Client, ref *PayloadRef) ([]byte, error) {
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(ref.Bucket), Key: aws.String(ref.Key)})
+	if err != nil {
+		return nil, fmt.Errorf("get s3://%s/%s: %w", ref.Bucket, ref.Key, err)
+	}
+	defer out.Body.Close()
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read s3://%s/%s: %w", ref.Bucket, ref.Key, err)
+	}
+	return b, nil
+}
+
+// OutputRefOf 返回 Output 中的 outputRef 指针；Output 不是转存后的指针时返回 nil。
+func OutputRefOf(output []byte) *PayloadRef {
+	var ptr struct {
+		OutputRef *PayloadRef `json:"outputRef"`
+	}
+	if err := json.Unmarshal(output, &ptr); err != nil {
+		return nil
+	}
+	return ptr.OutputRef
+}
@@ -0,0 +1,218 @@
+package claimcheck_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"testsqs/internal/claimcheck"
+)
+
+// fakeS3 是只支持 path-style PutObject/GetObject 的内存 S3，对象以 /<bucket>/<key> 为键。
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = b
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Write(b)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// newFakeS3 启动 fakeS3，并通过 S3_ENDPOINT_URL 让 NewS3Client 指向它。
+func newFakeS3(t *testing.T) (*fakeS3, context.Context) {
+	t.Helper()
+	fake := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	t.Setenv("S3_ENDPOINT_URL", srv.URL)
+	t.Setenv("PAYLOAD_BUCKET", "payloads")
+	return fake, context.Background()
+}
+
+func s3Config() aws.Config {
+	return aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test", "test", ""),
+	}
+}
+
+func TestOffloadFetchRoundTrip(t *testing.T) {
+	fake, ctx := newFakeS3(t)
+	client := claimcheck.NewS3Client(s3Config())
+
+	cases := []struct {
+		name     string
+		key      string
+		body     []byte
+		encoding string
+	}{
+		{name: "json", key: "messages/m-1.json", body: []byte(`{"id":"m-1","padding":"` + strings.Repeat("x", 300000) + `"}`)},
+		{name: "encoded", key: "messages/m-2.json", body: []byte("H4sIAAAAAAAA/6pWykxRslIqSS0uUaoFAAAA//8BAAD//w=="), encoding: "gzip+base64"},
+		{name: "empty", key: "outputs/m-3.json", body: []byte{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := claimcheck.Offload(ctx, client, tc.key, tc.body, tc.encoding)
+			if err != nil {
+				t.Fatalf("Offload: %v", err)
+			}
+			want := claimcheck.PayloadRef{Bucket: "payloads", Key: tc.key, Bytes: len(tc.body), Encoding: tc.encoding}
+			if *ref != want {
+				t.Fatalf("ref = %+v, want %+v", *ref, want)
+			}
+			if stored := fake.objects["/payloads/"+tc.key]; !bytes.Equal(stored, tc.body) {
+				t.Fatalf("stored %d bytes, want %d", len(stored), len(tc.body))
+			}
+
+			// 指针随消息体序列化后再取回：Encoding 必须保留，未编码时省略。
+			b, err := json.Marshal(ref)
+			if err != nil {
+				t.Fatalf("marshal ref: %v", err)
+			}
+			if got := strings.Contains(string(b), `"encoding"`); got != (tc.encoding != "") {
+				t.Fatalf("encoding field present=%v in %s", got, b)
+			}
+			var decoded claimcheck.PayloadRef
+			if err := json.Unmarshal(b, &decoded); err != nil {
+				t.Fatalf("unmarshal ref: %v", err)
+			}
+			got, err := claimcheck.Fetch(ctx, client, &decoded)
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			if !bytes.Equal(got, tc.body) {
+				t.Fatalf("Fetch returned %d bytes, want %d", len(got), len(tc.body))
+			}
+		})
+	}
+}
+
+func TestOutputRefRoundTrip(t *testing.T) {
+	_, ctx := newFakeS3(t)
+	client := claimcheck.NewS3Client(s3Config())
+
+	output := []byte(`{"id":"m-1","runId":"r-1","result":{"payload":"` + strings.Repeat("y", 1024) + `"}}`)
+	ref, err := claimcheck.Offload(ctx, client, "outputs/m-1.json", output, "")
+	if err != nil {
+		t.Fatalf("Offload: %v", err)
+	}
+	callback, err := json.Marshal(claimcheck.OffloadedOutput{ID: "m-1", RunID: "r-1", OutputRef: ref})
+	if err != nil {
+		t.Fatalf("marshal callback output: %v", err)
+	}
+
+	got := claimcheck.OutputRefOf(callback)
+	if got == nil || *got != *ref {
+		t.Fatalf("OutputRefOf = %+v, want %+v", got, ref)
+	}
+	full, err := claimcheck.Fetch(ctx, client, got)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if !bytes.Equal(full, output) {
+		t.Fatalf("Fetch = %s, want %s", full, output)
+	}
+
+	for _, plain := range []string{`{"id":"m-2","runId":"r-1"}`, `[{"id":"m-3"}]`, `not json`, ``} {
+		if ref := claimcheck.OutputRefOf([]byte(plain)); ref != nil {
+			t.Errorf("OutputRefOf(%q) = %+v, want nil", plain, ref)
+		}
+	}
+}
+
+func TestFetchMissingObject(t *testing.T) {
+	_, ctx := newFakeS3(t)
+	client := claimcheck.NewS3Client(s3Config())
+
+	_, err := claimcheck.Fetch(ctx, client, &claimcheck.PayloadRef{Bucket: "payloads", Key: "messages/missing.json"})
+	var noKey *s3types.NoSuchKey
+	if !errors.As(err, &noKey) {
+		t.Fatalf("Fetch error = %v, want NoSuchKey", err)
+	}
+}
+
+func TestOffloadWithoutBucket(t *testing.T) {
+	_, ctx := newFakeS3(t)
+	t.Setenv("PAYLOAD_BUCKET", "")
+	client := claimcheck.NewS3Client(s3Config())
+
+	if _, err := claimcheck.Offload(ctx, client, "messages/m-1.json", []byte(`{}`), ""); err == nil || !strings.Contains(err.Error(), "PAYLOAD_BUCKET") {
+		t.Fatalf("Offload error = %v, want missing PAYLOAD_BUCKET", err)
+	}
+}
+
+func TestThresholdBytes(t *testing.T) {
+	cases := []struct {
+		env  string
+		want int
+	}{
+		{env: "", want: claimcheck.DefaultThresholdBytes},
+		{env: "1024", want: 1024},
+		{env: " 2048 ", want: 2048},
+		{env: "0", want: claimcheck.DefaultThresholdBytes},
+		{env: "-5", want: claimcheck.DefaultThresholdBytes},
+		{env: "abc", want: claimcheck.DefaultThresholdBytes},
+	}
+	for _, tc := range cases {
+		t.Setenv("PAYLOAD_OFFLOAD_THRESHOLD_BYTES", tc.env)
+		if got := claimcheck.ThresholdBytes(); got != tc.want {
+			t.Errorf("ThresholdBytes() with %q = %d, want %d", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestOutputThresholdBytes(t *testing.T) {
+	t.Setenv("PAYLOAD_OFFLOAD_THRESHOLD_BYTES", "")
+	cases := []struct {
+		fanout int
+		want   int
+	}{
+		{fanout: 0, want: claimcheck.DefaultThresholdBytes},
+		{fanout: 1, want: claimcheck.DefaultThresholdBytes},
+		{fanout: 2, want: claimcheck.DefaultThresholdBytes / 2},
+		{fanout: 40, want: claimcheck.DefaultThresholdBytes / 40},
+	}
+	for _, tc := range cases {
+		got := claimcheck.OutputThresholdBytes(tc.fanout)
+		if got != tc.want {
+			t.Errorf("OutputThresholdBytes(%d) = %d, want %d", tc.fanout, got, tc.want)
+		}
+		// N 个分支都贴着阈值时，Map 状态的输出数组（含逗号与方括号）仍不能超过 256KB。
+		if n := max(tc.fanout, 1); n*got+n+1 > 256*1024 {
+			t.Errorf("fanout=%d: %d branch outputs of %d bytes exceed the 256KB state output limit", tc.fanout, n, got)
+		}
+	}
+}
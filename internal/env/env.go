@@ -0,0 +1,21 @@
+// Package env 读取 Lambda 环境变量配置，供 cmd/api、cmd/dispatcher、cmd/worker 共用。
+package env
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Int 返回环境变量 key 的正整数值；未设置、无法解析或不是正数时返回 def。
+func Int(key string, def int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,23 @@
+// Package tasktable 集中 TestTable（DynamoDB）中 Dispatcher、Worker 与 ApiFunction 共同约定的键与属性：
+// 任务条目以消息 id 为键，完成记录以 run#<runId> 为键，所有条目带 TTL 属性 expiresAt。
+package tasktable
+
+import (
+	"time"
+
+	"testsqs/internal/env"
+)
+
+// defaultItemTTLSeconds：ITEM_TTL_SECONDS 未配置时条目保留 7 天。
+const defaultItemTTLSeconds = 7 * 24 * 3600
+
+// CompletionRecordKey 返回 runId 对应的完成记录键（id=run#<runId>）。
+func CompletionRecordKey(runID string) string {
+	return "run#" + runID
+}
+
+// ExpiresAt 返回 TTL 属性 expiresAt 的值（epoch 秒，ITEM_TTL_SECONDS，默认 7 天）。
+func ExpiresAt() int64 {
+	ttl := env.Int("ITEM_TTL_SECONDS", defaultItemTTLSeconds)
+	return time.Now().Add(time.Duration(ttl) * time.Second).Unix()
+}
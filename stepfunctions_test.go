@@ -3,13 +3,24 @@ package main_test
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
+	"math/bits"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -22,6 +33,8 @@ type execOutput struct {
 	ID                      string `json:"id"`
 	RunID                   string `json:"runId"`
 	QueueName               string `json:"queueName"`
+	Lane                    string `json:"lane"`
+	Transport               string `json:"transport"`
 	SendUnixNano            int64  `json:"sendUnixNano"`
 	SendStartUnixNano       int64  `json:"sendStartUnixNano"`
 	ReceiveUnixNano         int64  `json:"receiveUnixNano"`
@@ -31,7 +44,21 @@ type execOutput struct {
 	SqsSentTimestampMs         int64  `json:"sqsSentTimestampMs"`
 	SqsFirstReceiveTimestampMs int64  `json:"sqsFirstReceiveTimestampMs"`
 	SqsApproxReceiveCount      int64  `json:"sqsApproxReceiveCount"`
+	SnsTimestampMs             int64  `json:"snsTimestampMs"`
+	KinesisArrivalTimestampMs  int64  `json:"kinesisArrivalTimestampMs"`
+	ShardID                    string `json:"shardId"`
+	DuplicateDeliveries        int64  `json:"duplicateDeliveries"`
+	LeaseTakeover              bool   `json:"leaseTakeover"`
 	Region                     string `json:"region"`
+
+	MessageGroupID string `json:"messageGroupId"`
+	SequenceNumber string `json:"sequenceNumber"`
+
+	Encoding    string `json:"encoding"`
+	RawBytes    int64  `json:"rawBytes"`
+	WireBytes   int64  `json:"wireBytes"`
+	EncodeNanos int64  `json:"encodeNanos"`
+	DecodeNanos int64  `json:"decodeNanos"`
 }
 
 type apiResponse struct {
@@ -40,15 +67,62 @@ type apiResponse struct {
 	TotalMs      int64           `json:"totalMs"`
 	Output       json.RawMessage `json:"output,omitempty"`
 	Error        string          `json:"error,omitempty"`
+	Fanout       *fanoutSummary  `json:"fanout,omitempty"`
+}
+
+// iterMetric 是一次成功迭代的分段耗时（毫秒），也是 RESULTS_DIR 下 iterations.csv / iterations.jsonl 的一行。
+type iterMetric struct {
+	Iter         int    `json:"iter"`
+	RunID        string `json:"runId"`
+	ExecutionArn string `json:"executionArn"`
+	Lane         string `json:"lane,omitempty"`
+	Shard        string `json:"shard,omitempty"`
+	TotalMs      int64  `json:"totalMs"`
+	SendToSqsMs  int64  `json:"sendToSqsMs"`
+	SqsWaitMs    int64  `json:"sqsWaitMs"`
+	WorkerMs     int64  `json:"workerMs"`
+	OverheadMs   int64  `json:"overheadMs"`
+	WallMs       int64  `json:"wallMs"`
+	ApiLambdaMs  int64  `json:"apiLambdaMs"`
+}
+
+// fanoutSummary 对应 ApiFunction 在扇出执行时返回的分支耗时汇总（只取报告需要的字段）。
+type fanoutSummary struct {
+	Branches        int     `json:"branches"`
+	MinMs           int64   `json:"minMs"`
+	AvgMs           float64 `json:"avgMs"`
+	P50Ms           int64   `json:"p50Ms"`
+	MaxMs           int64   `json:"maxMs"`
+	StragglerBranch int     `json:"stragglerBranch"`
+	StragglerMs     int64   `json:"stragglerMs"`
+}
+
+// apiStatusError 是 API 返回的非 2xx 响应。
+type apiStatusError struct {
+	StatusCode int
+	Body       string
 }
 
-func callRunAPI(ctx context.Context, apiEndpoint string, payload any, timeout time.Duration) (apiResponse, error) {
+func (e *apiStatusError) Error() string {
+	return fmt.Sprintf("api status=%d body=%s", e.StatusCode, e.Body)
+}
+
+// apiRequestTimeout 是单次 POST /run 的客户端超时（请求中 maxWaitMs=25000，API Gateway 集成超时为 29s）。
+const apiRequestTimeout = 28 * time.Second
+
+// newAPIClient 返回所有迭代共用的 http.Client：复用 TCP/TLS 连接，避免每次请求重新握手把建连耗时计入延迟。
+// maxConns 是同时在途请求数的上限，空闲连接池至少保留这么多连接（默认 MaxIdleConnsPerHost 只有 2，并发时多出的连接会被关闭后重建）。
+func newAPIClient(maxConns int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = max(transport.MaxIdleConns, maxConns)
+	transport.MaxIdleConnsPerHost = max(maxConns, 1)
+	return &http.Client{Timeout: apiRequestTimeout, Transport: transport}
+}
+
+func callRunAPI(ctx context.Context, client *http.Client, apiEndpoint string, payload any) (apiResponse, error) {
 	if apiEndpoint == "" {
 		return apiResponse{}, fmt.Errorf("missing api endpoint")
 	}
-	if timeout <= 0 {
-		timeout = 28 * time.Second
-	}
 
 	// ApiEndpoint output already includes /run, but keep this robust.
 	url := apiEndpoint
@@ -67,7 +141,6 @@ func callRunAPI(ctx context.Context, apiEndpoint string, payload any, timeout ti
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: timeout}
 	resp, err := client.Do(req)
 	if err != nil {
 		return apiResponse{}, fmt.Errorf("http request: %w", err)
@@ -76,7 +149,10 @@ func callRunAPI(ctx context.Context, apiEndpoint string, payload any, timeout ti
 
 	bodyBytes, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return apiResponse{}, fmt.Errorf("api status=%d body=%s", resp.StatusCode, string(bodyBytes))
+		// 保留响应体中的 status（例如 504 的 TIMEOUT），便于开环模式区分超时与错误。
+		var out apiResponse
+		_ = json.Unmarshal(bodyBytes, &out)
+		return out, &apiStatusError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
 	}
 
 	var out apiResponse
@@ -100,8 +176,118 @@ func TestStepFunctionsFlowLatency(t *testing.T) {
 	if repeat <= 0 {
 		repeat = 1
 	}
+	// 可选：poll/ddb；为空时由 ApiFunction 的 COMPLETION_MODE 决定。
+	completionMode := os.Getenv("COMPLETION_MODE")
+	// 可选：STANDARD/EXPRESS；EXPRESS 由 ApiFunction 使用 StartSyncExecution，便于同一套测试对比两种 workflow。
+	workflowType := os.Getenv("WORKFLOW_TYPE")
+	// 可选：Worker 模拟工作耗时（毫秒）。
+	workMs := getenvIntDefault("WORK_MS", 0)
+	// 可选：Worker 处理器（sleep/cpu/ddb/echo）。
+	taskType := os.Getenv("TASK_TYPE")
+	// 可选：消息体填充字节数；超过 256KB 时走 S3 claim-check（配合 TASK_TYPE=echo 同时验证 Output 转存）。
+	messageBodyBytes := getenvIntDefault("MESSAGE_BODY_BYTES", 0)
+	// 可选：填充内容（repeated/random/json）与消息体压缩（none/gzip/zstd），用于评估压缩是否划算。
+	contentMode := os.Getenv("CONTENT_MODE")
+	compression := os.Getenv("COMPRESSION")
+	// 可选：FIFO=1 时请求发送到 FIFO 队列，所有迭代共用一个消息组，用于对比 FIFO 与标准队列的延迟与顺序。
+	fifo := os.Getenv("FIFO") == "1"
+	messageGroupID := ""
+	if fifo {
+		messageGroupID = fmt.Sprintf("harness-%d", time.Now().UnixNano())
+	}
+	// 可选：TRANSPORT=sqs/sns/sns-sqs 选择 Dispatcher 与 Worker 之间的传输方式（默认 sqs）。
+	transport := os.Getenv("TRANSPORT")
+	// 可选：LANES=high,low,low 按迭代轮流指定 priority（路由通道），用于对比各通道在混合负载下的延迟。
+	var lanes []string
+	for _, l := range strings.Split(os.Getenv("LANES"), ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			lanes = append(lanes, l)
+		}
+	}
+	// 可选：CONCURRENCY=K 以 K 个并发调用方执行 REPEAT 次迭代（默认 1，即逐次顺序执行），结果头部输出实际吞吐（runs/s）。
+	concurrency := getenvIntDefault("CONCURRENCY", 1)
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	thresholds, err := parseThresholds(getenvDefault("REGRESSION_THRESHOLDS", "p95:20"))
+	if err != nil {
+		t.Fatalf("invalid REGRESSION_THRESHOLDS: %v", err)
+	}
+	gatedColumns := strings.Split(getenvDefault("REGRESSION_METRICS", "totalMs"), ",")
+	alpha, err := strconv.ParseFloat(getenvDefault("REGRESSION_ALPHA", "0.05"), 64)
+	if err != nil || alpha <= 0 || alpha >= 1 {
+		t.Fatalf("invalid REGRESSION_ALPHA %q", os.Getenv("REGRESSION_ALPHA"))
+	}
+	// 可选：FANOUT=N 每次迭代由 Map 扇出状态机并行分发 N 个任务；分解计时取最慢分支（straggler）的 Output。
+	fanout := getenvIntDefault("FANOUT", 0)
+
+	// 可选：RATE=50/s DURATION=5m 开环压测：按固定速率发出 RATE×DURATION 个请求，不受 REPEAT/CONCURRENCY 影响。
+	rate, err := parseRate(os.Getenv("RATE"))
+	if err != nil {
+		t.Fatalf("invalid RATE: %v", err)
+	}
+	openLoop := rate > 0
+	duration := time.Minute
+	if v := os.Getenv("DURATION"); v != "" {
+		if duration, err = time.ParseDuration(v); err != nil || duration <= 0 {
+			t.Fatalf("invalid DURATION %q", v)
+		}
+	}
+	if openLoop {
+		repeat = max(1, int(rate*duration.Seconds()))
+	}
+
+	// 压测参数：写入 summary.json，并在对比基线前检查两次运行是否可比（见 baselineMismatches）。
+	mode, totalMsBasis := "closed-loop", "api"
+	if openLoop {
+		mode, totalMsBasis = "open-loop", "corrected"
+	}
+	parameters := map[string]any{
+		"mode":             mode,
+		"totalMs":          totalMsBasis,
+		"repeat":           repeat,
+		"concurrency":      concurrency,
+		"rate":             rate,
+		"duration":         duration.String(),
+		"transport":        transport,
+		"lanes":            lanes,
+		"fifo":             fifo,
+		"fanout":           fanout,
+		"workMs":           workMs,
+		"taskType":         taskType,
+		"messageBodyBytes": messageBodyBytes,
+		"contentMode":      contentMode,
+		"compression":      compression,
+		"completionMode":   completionMode,
+		"workflowType":     workflowType,
+	}
+
+	// 可选：BASELINE 指向此前某次运行的结果目录（或其中的 iterations.jsonl），与本次的 warm 迭代逐列对比分位数，
+	// 超过 REGRESSION_THRESHOLDS（默认 p95:20，即 p95 变慢 20%）且 Mann-Whitney 检验显著（p < REGRESSION_ALPHA，默认 0.05）时测试失败。
+	// 基线的 summary.json 记录的关键参数与本次不同时拒绝对比（在发出任何请求之前失败）；BASELINE_ALLOW_MISMATCH=1 时仍然对比，只在结果中警告。
+	var baseline []iterMetric
+	var baselineWarnings []string
+	baselinePath := os.Getenv("BASELINE")
+	if baselinePath != "" {
+		var baseParams map[string]any
+		if baseline, baseParams, err = loadBaseline(baselinePath); err != nil {
+			t.Fatalf("load BASELINE: %v", err)
+		}
+		if baseParams == nil {
+			baselineWarnings = append(baselineWarnings, "baseline has no summary.json, parameters not verified")
+		} else if diffs := baselineMismatches(baseParams, parameters); len(diffs) > 0 {
+			if os.Getenv("BASELINE_ALLOW_MISMATCH") != "1" {
+				t.Fatalf("BASELINE parameters differ from this run (set BASELINE_ALLOW_MISMATCH=1 to compare anyway): %s", strings.Join(diffs, "; "))
+			}
+			baselineWarnings = append(baselineWarnings, "parameters differ: "+strings.Join(diffs, "; "))
+		}
+		for _, w := range baselineWarnings {
+			t.Logf("BASELINE: %s", w)
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Minute)
+	// 开环压测需要覆盖 DURATION 本身，再加上最后一批请求的等待时间。
+	ctx, cancel := context.WithTimeout(context.Background(), max(12*time.Minute, duration+2*time.Minute))
 	defer cancel()
 
 	cfg, err := config.LoadDefaultConfig(ctx)
@@ -119,77 +305,220 @@ func TestStepFunctionsFlowLatency(t *testing.T) {
 		t.Fatalf("resolve ApiEndpoint: %v", err)
 	}
 
-	latenciesMs := make([]int64, 0, repeat)
-	var sumMs int64
-	var minMs int64
-	var maxMs int64
-
-	var sumSendMs int64
-	var sumSqsWaitMs int64
-	var sumWorkerMs int64
-	var sumOverheadMs int64
+	// 发生过 SQS 重投（receiveCount>1、跳过了重复投递或接管了租约）的迭代数：这些迭代的耗时包含重投等待，解读统计时需要注意。
+	var redelivered int
+	// SNS→SQS 投递：SNS 接受发布到消息进入 SQS 的耗时（累计，输出平均值）。
+	var sumSnsToSqsMs, snsToSqsCount int64
+	// 消息体大小与编解码耗时（累计，输出平均值）。
+	var sumRawBytes, sumWireBytes, sumEncodeNanos, sumDecodeNanos int64
+	// 顺序：按发送时间排序后，接收时间早于此前任一消息的视为乱序。
+	type orderSample struct{ SendUnixNano, ReceiveUnixNano int64 }
+	orderSamples := make([]orderSample, 0, repeat)
 
-	type iterMetric struct {
-		Iter        int
-		TotalMs     int64
-		SendToSqsMs int64
-		SqsWaitMs   int64
-		WorkerMs    int64
-		OverheadMs  int64
-		WallMs      int64
-		ApiLambdaMs int64
-	}
 	metrics := make([]iterMetric, 0, repeat)
+	// 带分片信息（transport=kinesis）的迭代数。
+	var shards int
+	// 扇出迭代的分支耗时汇总（与 metrics 按迭代对应）。
+	fanouts := make([]fanoutSummary, 0, repeat)
 
-	var minSendMs, maxSendMs int64
-	var minSqsWaitMs, maxSqsWaitMs int64
-	var minWorkerMs, maxWorkerMs int64
-	var minOverheadMs, maxOverheadMs int64
+	// 所有迭代共用一个 http.Client。连接池按同时在途请求数的上限设置：闭环为 CONCURRENCY；
+	// 开环为 RATE × 单次请求超时（请求在超时前都可能在途）。
+	maxConns := concurrency
+	if openLoop {
+		maxConns = int(math.Ceil(rate * apiRequestTimeout.Seconds()))
+	}
+	apiClient := newAPIClient(maxConns)
+	defer apiClient.CloseIdleConnections()
 
-	for i := 0; i < repeat; i++ {
+	// 每次迭代的请求体与结果检查：闭环与开环两种压测方式共用。
+	runOnce := func(ctx context.Context, i int) (string, apiResponse, error) {
 		runID := fmt.Sprintf("run-%d-%d", i, time.Now().UnixNano())
-
-		startWall := time.Now()
-		apiOut, err := callRunAPI(ctx, apiEndpoint, map[string]any{
+		priority := ""
+		if len(lanes) > 0 {
+			priority = lanes[i%len(lanes)]
+		}
+		apiOut, err := callRunAPI(ctx, apiClient, apiEndpoint, map[string]any{
 			"runId":            runID,
-			"messageBodyBytes": 0,
+			"messageBodyBytes": messageBodyBytes,
+			"contentMode":      contentMode,
+			"compression":      compression,
+			"fifo":             fifo,
+			"messageGroupId":   messageGroupID,
+			"priority":         priority,
+			"transport":        transport,
+			"completionMode":   completionMode,
+			"workflowType":     workflowType,
+			"workMs":           workMs,
+			"taskType":         taskType,
+			"fanout":           fanout,
 			// 避免 API Gateway 29s 超时；默认由 ApiFunction 控制为 25s。
 			"maxWaitMs": 25000,
-		}, 28*time.Second)
-		if err != nil {
-			t.Fatalf("call api [%d/%d]: %v", i+1, repeat, err)
+		})
+		switch {
+		case err != nil:
+			err = fmt.Errorf("call api [%d/%d]: %w", i+1, repeat, err)
+		case apiOut.Status != "SUCCEEDED":
+			err = fmt.Errorf("api status not succeeded [%d/%d]: status=%s error=%s", i+1, repeat, apiOut.Status, apiOut.Error)
+		case apiOut.ExecutionArn == "":
+			err = fmt.Errorf("api missing executionArn [%d/%d]", i+1, repeat)
 		}
-		if apiOut.Status != "SUCCEEDED" {
-			t.Fatalf("api status not succeeded [%d/%d]: status=%s error=%s", i+1, repeat, apiOut.Status, apiOut.Error)
+		return runID, apiOut, err
+	}
+
+	type runResult struct {
+		iter   int
+		runID  string
+		ok     bool
+		apiOut apiResponse
+		wallMs int64
+		// 开环模式：outcome 为 ok/timeout/error，correctedMs 为计划发送时间到收到响应的耗时。
+		outcome     string
+		correctedMs int64
+	}
+	results := make([]runResult, repeat)
+	loadStart := time.Now()
+	// 开环模式：实际发出时间晚于计划发送时间的最大值（调度滞后）。
+	var maxScheduleLag time.Duration
+	if openLoop {
+		// 开环压测：按固定时间表（第 i 个请求计划在 loadStart + i/RATE 发出）为每个请求单独启动 goroutine，不等待前一个完成；
+		// 延迟从计划发送时间算起，客户端调度滞后也计入延迟（coordinated omission 校正）。错误与超时不终止测试，单独统计。
+		interval := time.Duration(float64(time.Second) / rate)
+		var wg sync.WaitGroup
+		for i := 0; i < repeat; i++ {
+			intended := loadStart.Add(time.Duration(i) * interval)
+			time.Sleep(time.Until(intended))
+			maxScheduleLag = max(maxScheduleLag, time.Since(intended))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				startWall := time.Now()
+				runID, apiOut, err := runOnce(ctx, i)
+				r := runResult{
+					iter:        i + 1,
+					runID:       runID,
+					ok:          err == nil,
+					apiOut:      apiOut,
+					wallMs:      time.Since(startWall).Milliseconds(),
+					outcome:     classifyOutcome(apiOut, err),
+					correctedMs: time.Since(intended).Milliseconds(),
+				}
+				if err != nil {
+					t.Logf("%v", err)
+				}
+				results[i] = r
+			}()
+		}
+		wg.Wait()
+	} else {
+		// 闭环压测：CONCURRENCY 个 goroutine 从 jobs 领取迭代序号并调用 API，结果写入各自序号的槽位（互不共享，无需加锁）；
+		// 全部完成后再按迭代顺序汇总。第 1 次迭代先单独执行，完成后才放开并发：否则前 CONCURRENCY 个迭代都会赶上冷启动，
+		// 却只有第 1 次被标为 cold，其余冷启动样本混进 warm 统计（吞吐因此包含这一次串行的冷启动）。
+		var errOnce sync.Once
+		var firstErr error
+		loadCtx, cancelLoad := context.WithCancel(ctx)
+		defer cancelLoad()
+		runJob := func(i int) {
+			startWall := time.Now()
+			runID, apiOut, err := runOnce(loadCtx, i)
+			if err != nil {
+				// 只保留最先发生的错误，并取消其余在途请求。
+				errOnce.Do(func() {
+					firstErr = err
+					cancelLoad()
+				})
+				return
+			}
+			results[i] = runResult{iter: i + 1, runID: runID, ok: true, apiOut: apiOut, wallMs: time.Since(startWall).Milliseconds()}
 		}
-		execArn := apiOut.ExecutionArn
-		if execArn == "" {
-			t.Fatalf("api missing executionArn [%d/%d]", i+1, repeat)
+		runJob(0)
+
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < min(concurrency, repeat-1); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					runJob(i)
+				}
+			}()
+		}
+		for i := 1; i < repeat && loadCtx.Err() == nil; i++ {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		if firstErr != nil {
+			t.Fatal(firstErr)
+		}
+	}
+	loadElapsed := time.Since(loadStart)
+
+	// 分解计时只统计成功的迭代（闭环模式下全部成功）。
+	completed := make([]runResult, 0, len(results))
+	for _, r := range results {
+		if r.ok {
+			completed = append(completed, r)
 		}
+	}
+	if len(completed) == 0 {
+		t.Fatalf("no successful runs out of %d", repeat)
+	}
+
+	for _, r := range completed {
+		apiOut := r.apiOut
 		var output execOutput
-		if len(apiOut.Output) > 0 {
+		if apiOut.Fanout != nil {
+			var branches []execOutput
+			_ = json.Unmarshal(apiOut.Output, &branches)
+			if b := apiOut.Fanout.StragglerBranch; b >= 0 && b < len(branches) {
+				output = branches[b]
+			}
+			fanouts = append(fanouts, *apiOut.Fanout)
+		} else if len(apiOut.Output) > 0 {
 			_ = json.Unmarshal(apiOut.Output, &output)
 		}
 
-		wallMs := time.Since(startWall).Milliseconds()
+		wallMs := r.wallMs
+		if output.SqsApproxReceiveCount > 1 || output.DuplicateDeliveries > 0 || output.LeaseTakeover {
+			redelivered++
+		}
+		if output.SendUnixNano > 0 && output.ReceiveUnixNano > 0 {
+			orderSamples = append(orderSamples, orderSample{output.SendUnixNano, output.ReceiveUnixNano})
+		}
+		sumRawBytes += output.RawBytes
+		sumWireBytes += output.WireBytes
+		sumEncodeNanos += output.EncodeNanos
+		sumDecodeNanos += output.DecodeNanos
 
-		// Express + StartSyncExecution：直接以 API Lambda 侧测得的等待时间作为总耗时。
+		// 以 API Lambda 侧测得的等待时间作为总耗时（Standard：启动到判定完成；Express：StartSyncExecution 耗时）。
 		// 退化：如果 apiOut.TotalMs 不可用，则用墙钟时间。
 		latencyMs := apiOut.TotalMs
 		if latencyMs <= 0 {
 			latencyMs = wallMs
 		}
-		latenciesMs = append(latenciesMs, latencyMs)
-		sumMs += latencyMs
-		if i == 0 || latencyMs < minMs {
-			minMs = latencyMs
-		}
-		if i == 0 || latencyMs > maxMs {
-			maxMs = latencyMs
+		// 开环：totalMs 取校正后的延迟（计划发送时间到收到响应），分位数、直方图与基线门禁因此都包含客户端调度滞后；
+		// 未校正的耗时仍保留在 apiLambdaMs（API 侧）与 wallMs（实际发出到收到响应）中；调度滞后计入 overheadMs。
+		if openLoop {
+			latencyMs = r.correctedMs
 		}
 
 		// 分布计时：不再依赖 DynamoDB；全部由“消息 + Worker Output”携带的时间戳计算。
+		// 传输层时间戳取最早的一跳：SNS 传输为 SNS Timestamp（sns-sqs 的 SQS SentTimestamp 晚于它），
+		// Kinesis 为记录的 ApproximateArrivalTimestamp，其余为 SQS SentTimestamp。
+		// EventBridge 的事件 time 只有秒级精度、Lambda 直接异步调用没有传输层时间戳，都不作分界：
+		// sendToSqsMs 为 0，sqsWaitMs 为发送开始到 Worker 接收（含 PutEvents / Invoke 与异步调用排队）。
 		sqsSentUnixNano := output.SqsSentTimestampMs * int64(time.Millisecond)
+		if output.SnsTimestampMs > 0 {
+			if output.SqsSentTimestampMs > 0 {
+				sumSnsToSqsMs += output.SqsSentTimestampMs - output.SnsTimestampMs
+				snsToSqsCount++
+			}
+			sqsSentUnixNano = output.SnsTimestampMs * int64(time.Millisecond)
+		}
+		if output.KinesisArrivalTimestampMs > 0 {
+			sqsSentUnixNano = output.KinesisArrivalTimestampMs * int64(time.Millisecond)
+		}
 
 		sendToSqsMs := int64(0)
 		if output.SendStartUnixNano > 0 && sqsSentUnixNano > 0 {
@@ -226,127 +555,88 @@ func TestStepFunctionsFlowLatency(t *testing.T) {
 			overheadMs = 0
 		}
 
-		sumSendMs += sendToSqsMs
-		sumSqsWaitMs += sqsWaitMs
-		sumWorkerMs += workerMs
-		sumOverheadMs += overheadMs
-
+		lane := output.Lane
+		if lane == "" {
+			lane = output.QueueName
+		}
+		if output.ShardID != "" {
+			shards++
+		}
 		metrics = append(metrics, iterMetric{
-			Iter:        i + 1,
-			TotalMs:     latencyMs,
-			SendToSqsMs: sendToSqsMs,
-			SqsWaitMs:   sqsWaitMs,
-			WorkerMs:    workerMs,
-			OverheadMs:  overheadMs,
-			WallMs:      wallMs,
-			ApiLambdaMs: apiOut.TotalMs,
+			Iter:         r.iter,
+			RunID:        r.runID,
+			ExecutionArn: apiOut.ExecutionArn,
+			Lane:         lane,
+			Shard:        output.ShardID,
+			TotalMs:      latencyMs,
+			SendToSqsMs:  sendToSqsMs,
+			SqsWaitMs:    sqsWaitMs,
+			WorkerMs:     workerMs,
+			OverheadMs:   overheadMs,
+			WallMs:       wallMs,
+			ApiLambdaMs:  apiOut.TotalMs,
 		})
-		if i == 0 {
-			minSendMs, maxSendMs = sendToSqsMs, sendToSqsMs
-			minSqsWaitMs, maxSqsWaitMs = sqsWaitMs, sqsWaitMs
-			minWorkerMs, maxWorkerMs = workerMs, workerMs
-			minOverheadMs, maxOverheadMs = overheadMs, overheadMs
-		} else {
-			if sendToSqsMs < minSendMs {
-				minSendMs = sendToSqsMs
-			}
-			if sendToSqsMs > maxSendMs {
-				maxSendMs = sendToSqsMs
-			}
-			if sqsWaitMs < minSqsWaitMs {
-				minSqsWaitMs = sqsWaitMs
-			}
-			if sqsWaitMs > maxSqsWaitMs {
-				maxSqsWaitMs = sqsWaitMs
-			}
-			if workerMs < minWorkerMs {
-				minWorkerMs = workerMs
-			}
-			if workerMs > maxWorkerMs {
-				maxWorkerMs = workerMs
-			}
-			if overheadMs < minOverheadMs {
-				minOverheadMs = overheadMs
-			}
-			if overheadMs > maxOverheadMs {
-				maxOverheadMs = overheadMs
-			}
-		}
 	}
 
-	den := float64(len(latenciesMs))
-	avgTotalMs := float64(sumMs) / den
-	avgSendMs := float64(sumSendMs) / den
-	avgSqsWaitMs := float64(sumSqsWaitMs) / den
-	avgWorkerMs := float64(sumWorkerMs) / den
-	avgOverheadMs := float64(sumOverheadMs) / den
-
-	// 冷启动：将第 1 次迭代单独作为“冷启动样本”输出；其余迭代作为 warm 统计。
+	// 冷启动：闭环时将第 1 次迭代单独作为“冷启动样本”输出；其余迭代作为 warm 统计。
 	// 说明：这里的“冷启动”是端到端视角（API/Dispatcher/Worker 任一环节冷启动都会体现到总耗时上）。
+	// 开环不区分冷启动：请求按时间表并发发出，冷启动可能落在前几个请求中的任意几个上，只把第 1 次标为 cold 并不成立；
+	// 此时 warm 即全部成功的请求。
+	coldSplit := !openLoop
 	cold := iterMetric{}
-	if len(metrics) > 0 {
+	warm := metrics
+	if coldSplit && len(metrics) > 0 {
 		cold = metrics[0]
-	}
-
-	warmCount := 0
-	var warmSumTotal, warmSumSend, warmSumWait, warmSumWorker, warmSumOverhead int64
-	var warmMinTotal, warmMaxTotal int64
-	var warmMinSend, warmMaxSend int64
-	var warmMinWait, warmMaxWait int64
-	var warmMinWorker, warmMaxWorker int64
-	var warmMinOverhead, warmMaxOverhead int64
-
-	for i := 1; i < len(metrics); i++ {
-		m := metrics[i]
-		warmCount++
-		warmSumTotal += m.TotalMs
-		warmSumSend += m.SendToSqsMs
-		warmSumWait += m.SqsWaitMs
-		warmSumWorker += m.WorkerMs
-		warmSumOverhead += m.OverheadMs
-		if warmCount == 1 {
-			warmMinTotal, warmMaxTotal = m.TotalMs, m.TotalMs
-			warmMinSend, warmMaxSend = m.SendToSqsMs, m.SendToSqsMs
-			warmMinWait, warmMaxWait = m.SqsWaitMs, m.SqsWaitMs
-			warmMinWorker, warmMaxWorker = m.WorkerMs, m.WorkerMs
-			warmMinOverhead, warmMaxOverhead = m.OverheadMs, m.OverheadMs
-		} else {
-			if m.TotalMs < warmMinTotal {
-				warmMinTotal = m.TotalMs
-			}
-			if m.TotalMs > warmMaxTotal {
-				warmMaxTotal = m.TotalMs
-			}
-			if m.SendToSqsMs < warmMinSend {
-				warmMinSend = m.SendToSqsMs
-			}
-			if m.SendToSqsMs > warmMaxSend {
-				warmMaxSend = m.SendToSqsMs
-			}
-			if m.SqsWaitMs < warmMinWait {
-				warmMinWait = m.SqsWaitMs
-			}
-			if m.SqsWaitMs > warmMaxWait {
-				warmMaxWait = m.SqsWaitMs
-			}
-			if m.WorkerMs < warmMinWorker {
-				warmMinWorker = m.WorkerMs
-			}
-			if m.WorkerMs > warmMaxWorker {
-				warmMaxWorker = m.WorkerMs
-			}
-			if m.OverheadMs < warmMinOverhead {
-				warmMinOverhead = m.OverheadMs
-			}
-			if m.OverheadMs > warmMaxOverhead {
-				warmMaxOverhead = m.OverheadMs
-			}
-		}
+		warm = warmRows(metrics)
 	}
 
 	// 输出：Markdown（写 stdout，避免 go test 为 log 行追加缩进/前缀导致表格看起来不整齐，也便于脚本提取写入 result.md）。
 	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "stateMachine=%s\napi=%s\n\n", stateMachineArn, apiEndpoint)
+	fmt.Fprintf(&buf, "stateMachine=%s\napi=%s\n", stateMachineArn, apiEndpoint)
+	if workflowType != "" {
+		fmt.Fprintf(&buf, "workflowType=%s\n", workflowType)
+	}
+	if transport != "" {
+		fmt.Fprintf(&buf, "transport=%s\n", transport)
+		if snsToSqsCount > 0 {
+			fmt.Fprintf(&buf, "avg snsToSqsMs=%.3f\n", float64(sumSnsToSqsMs)/float64(snsToSqsCount))
+		}
+	}
+	if taskType != "" || workMs > 0 {
+		fmt.Fprintf(&buf, "taskType=%s workMs=%d\n", taskType, workMs)
+	}
+	if fanout > 0 {
+		fmt.Fprintf(&buf, "fanout=%d\n", fanout)
+	}
+	if openLoop {
+		fmt.Fprintf(&buf, "rate=%.3f/s duration=%s scheduled=%d maxScheduleLagMs=%d\n", rate, duration, repeat, maxScheduleLag.Milliseconds())
+		buf.WriteString("totalMs=corrected (intended send -> response; uncorrected in apiLambdaMs/wallMs)\n")
+	} else {
+		fmt.Fprintf(&buf, "concurrency=%d\n", concurrency)
+	}
+	fmt.Fprintf(&buf, "succeeded=%d/%d elapsedMs=%d throughput=%.3f runs/s\n",
+		len(metrics), repeat, loadElapsed.Milliseconds(), float64(len(metrics))/loadElapsed.Seconds())
+	if messageBodyBytes > 0 || contentMode != "" || compression != "" {
+		fmt.Fprintf(&buf, "messageBodyBytes=%d contentMode=%s compression=%s\n", messageBodyBytes, contentMode, compression)
+		if n := int64(len(metrics)); n > 0 {
+			fmt.Fprintf(&buf, "avg rawBytes=%d wireBytes=%d encodeMs=%.3f decodeMs=%.3f\n",
+				sumRawBytes/n, sumWireBytes/n, float64(sumEncodeNanos)/float64(n)/1e6, float64(sumDecodeNanos)/float64(n)/1e6)
+		}
+	}
+	sort.Slice(orderSamples, func(a, b int) bool { return orderSamples[a].SendUnixNano < orderSamples[b].SendUnixNano })
+	var inversions int
+	var maxReceive int64
+	for _, s := range orderSamples {
+		if s.ReceiveUnixNano < maxReceive {
+			inversions++
+		}
+		maxReceive = max(maxReceive, s.ReceiveUnixNano)
+	}
+	fmt.Fprintf(&buf, "fifo=%t orderingInversions=%d/%d\n", fifo, inversions, len(orderSamples))
+	if redelivered > 0 {
+		fmt.Fprintf(&buf, "redelivered=%d/%d\n", redelivered, len(metrics))
+	}
+	buf.WriteString("\n")
 
 	buf.WriteString("### Latency Breakdown (ms)\n\n")
 	breakdownHeaders := []string{"iter", "totalMs", "sendToSqsMs", "sqsWaitMs", "workerMs", "overheadMs", "wallMs", "apiLambdaMs"}
@@ -366,55 +656,240 @@ func TestStepFunctionsFlowLatency(t *testing.T) {
 	}
 	buf.WriteString(formatMarkdownTable(breakdownHeaders, breakdownRight, breakdownRows))
 
-	// 冷启动独立表
-	buf.WriteString("\n### Cold Start (iter=1)\n\n")
-	coldHeaders := []string{"iter", "totalMs", "sendToSqsMs", "sqsWaitMs", "workerMs", "overheadMs", "wallMs", "apiLambdaMs"}
-	coldRight := []bool{true, true, true, true, true, true, true, true}
-	coldRows := [][]string{}
-	if len(metrics) > 0 {
-		coldRows = append(coldRows, []string{
-			fmt.Sprintf("%d", cold.Iter),
-			fmt.Sprintf("%d", cold.TotalMs),
-			fmt.Sprintf("%d", cold.SendToSqsMs),
-			fmt.Sprintf("%d", cold.SqsWaitMs),
-			fmt.Sprintf("%d", cold.WorkerMs),
-			fmt.Sprintf("%d", cold.OverheadMs),
-			fmt.Sprintf("%d", cold.WallMs),
-			fmt.Sprintf("%d", cold.ApiLambdaMs),
-		})
+	// 冷启动独立表（仅闭环）
+	if coldSplit {
+		buf.WriteString("\n### Cold Start (iter=1)\n\n")
+		coldHeaders := []string{"iter", "totalMs", "sendToSqsMs", "sqsWaitMs", "workerMs", "overheadMs", "wallMs", "apiLambdaMs"}
+		coldRight := []bool{true, true, true, true, true, true, true, true}
+		coldRows := [][]string{}
+		if len(metrics) > 0 {
+			coldRows = append(coldRows, []string{
+				fmt.Sprintf("%d", cold.Iter),
+				fmt.Sprintf("%d", cold.TotalMs),
+				fmt.Sprintf("%d", cold.SendToSqsMs),
+				fmt.Sprintf("%d", cold.SqsWaitMs),
+				fmt.Sprintf("%d", cold.WorkerMs),
+				fmt.Sprintf("%d", cold.OverheadMs),
+				fmt.Sprintf("%d", cold.WallMs),
+				fmt.Sprintf("%d", cold.ApiLambdaMs),
+			})
+		}
+		buf.WriteString(formatMarkdownTable(coldHeaders, coldRight, coldRows))
 	}
-	buf.WriteString(formatMarkdownTable(coldHeaders, coldRight, coldRows))
 
 	// warm summary（排除冷启动）
-	buf.WriteString("\n### Warm Summary (iter=2..N)\n\n")
-	summaryHeaders := []string{"metric", "totalMs", "sendToSqsMs", "sqsWaitMs", "workerMs", "overheadMs"}
-	summaryRight := []bool{false, true, true, true, true, true}
-	summaryRows := [][]string{}
-	if warmCount > 0 {
-		warmDen := float64(warmCount)
-		warmAvgTotal := float64(warmSumTotal) / warmDen
-		warmAvgSend := float64(warmSumSend) / warmDen
-		warmAvgWait := float64(warmSumWait) / warmDen
-		warmAvgWorker := float64(warmSumWorker) / warmDen
-		warmAvgOverhead := float64(warmSumOverhead) / warmDen
-		summaryRows = append(summaryRows,
-			[]string{"avg", fmt.Sprintf("%.3f", warmAvgTotal), fmt.Sprintf("%.3f", warmAvgSend), fmt.Sprintf("%.3f", warmAvgWait), fmt.Sprintf("%.3f", warmAvgWorker), fmt.Sprintf("%.3f", warmAvgOverhead)},
-			[]string{"min", fmt.Sprintf("%d", warmMinTotal), fmt.Sprintf("%d", warmMinSend), fmt.Sprintf("%d", warmMinWait), fmt.Sprintf("%d", warmMinWorker), fmt.Sprintf("%d", warmMinOverhead)},
-			[]string{"max", fmt.Sprintf("%d", warmMaxTotal), fmt.Sprintf("%d", warmMaxSend), fmt.Sprintf("%d", warmMaxWait), fmt.Sprintf("%d", warmMaxWorker), fmt.Sprintf("%d", warmMaxOverhead)},
-		)
-	} else {
-		summaryRows = append(summaryRows, []string{"warm", "n/a", "n/a", "n/a", "n/a", "n/a"})
+	// 各分解列的分布：每列一个 HDR 风格直方图（见 latencyHistogram），输出均值、标准差与分位数。
+	summaryTable := func(ms []iterMetric) string {
+		headers := append([]string{"metric"}, summaryColumns...)
+		right := []bool{false, true, true, true, true, true}
+		if len(ms) == 0 {
+			return formatMarkdownTable(headers, right, [][]string{{"n/a", "n/a", "n/a", "n/a", "n/a", "n/a"}})
+		}
+		hists := columnHistograms(ms)
+		row := func(name string, cell func(h *latencyHistogram) string) []string {
+			r := []string{name}
+			for _, h := range hists {
+				r = append(r, cell(h))
+			}
+			return r
+		}
+		rows := [][]string{
+			row("avg", func(h *latencyHistogram) string { return fmt.Sprintf("%.3f", h.Mean()) }),
+			row("stddev", func(h *latencyHistogram) string { return fmt.Sprintf("%.3f", h.StdDev()) }),
+			row("min", func(h *latencyHistogram) string { return fmt.Sprintf("%d", h.Min()) }),
+		}
+		for _, q := range reportPercentiles {
+			rows = append(rows, row(percentileLabel(q), func(h *latencyHistogram) string {
+				return fmt.Sprintf("%d", h.ValueAtPercentile(q))
+			}))
+		}
+		rows = append(rows, row("max", func(h *latencyHistogram) string { return fmt.Sprintf("%d", h.Max()) }))
+		return formatMarkdownTable(headers, right, rows)
+	}
+
+	// warm summary（排除冷启动；开环时与 All Summary 相同，不再重复输出）
+	if coldSplit {
+		buf.WriteString("\n### Warm Summary (iter=2..N)\n\n")
+		buf.WriteString(summaryTable(warm))
 	}
-	buf.WriteString(formatMarkdownTable(summaryHeaders, summaryRight, summaryRows))
 
 	// 保留整体 summary 供对比（包含 cold + warm）
 	buf.WriteString("\n### All Summary (iter=1..N)\n\n")
-	allRows := [][]string{
-		{"avg", fmt.Sprintf("%.3f", avgTotalMs), fmt.Sprintf("%.3f", avgSendMs), fmt.Sprintf("%.3f", avgSqsWaitMs), fmt.Sprintf("%.3f", avgWorkerMs), fmt.Sprintf("%.3f", avgOverheadMs)},
-		{"min", fmt.Sprintf("%d", minMs), fmt.Sprintf("%d", minSendMs), fmt.Sprintf("%d", minSqsWaitMs), fmt.Sprintf("%d", minWorkerMs), fmt.Sprintf("%d", minOverheadMs)},
-		{"max", fmt.Sprintf("%d", maxMs), fmt.Sprintf("%d", maxSendMs), fmt.Sprintf("%d", maxSqsWaitMs), fmt.Sprintf("%d", maxWorkerMs), fmt.Sprintf("%d", maxOverheadMs)},
+	buf.WriteString(summaryTable(metrics))
+
+	// totalMs 直方图（包含 cold + warm）：按 1-2-5 刻度分桶，冷启动等长尾单独落在高位桶中。
+	totalHist := &latencyHistogram{}
+	for _, m := range metrics {
+		totalHist.Record(m.TotalMs)
+	}
+	buf.WriteString("\n### totalMs Histogram (iter=1..N)\n\n")
+	buf.WriteString(totalHist.MarkdownBars(40))
+
+	// 按分组汇总 warm 迭代（闭环排除冷启动，避免第 1 次落入的分组被冷启动拉高）：多通道混合负载时对比各通道的排队等待；Kinesis 时对比各分片。
+	// 每个分组的 totalMs 与 sqsWaitMs 各用一个直方图，输出 p50/p95/p99 尾延迟而不只是均值。
+	groupTable := func(keyName string, key func(iterMetric) string) string {
+		type groupStat struct{ total, wait *latencyHistogram }
+		stats := map[string]*groupStat{}
+		order := []string{}
+		for _, m := range warm {
+			k := key(m)
+			st, ok := stats[k]
+			if !ok {
+				st = &groupStat{total: &latencyHistogram{}, wait: &latencyHistogram{}}
+				stats[k] = st
+				order = append(order, k)
+			}
+			st.total.Record(m.TotalMs)
+			st.wait.Record(m.SqsWaitMs)
+		}
+		sort.Strings(order)
+		headers := []string{keyName, "n", "p50TotalMs", "p95TotalMs", "p99TotalMs", "maxTotalMs", "p50SqsWaitMs", "p95SqsWaitMs", "p99SqsWaitMs"}
+		right := []bool{false, true, true, true, true, true, true, true, true}
+		rows := make([][]string, 0, len(order))
+		for _, k := range order {
+			st := stats[k]
+			rows = append(rows, []string{
+				k,
+				fmt.Sprintf("%d", st.total.Count()),
+				fmt.Sprintf("%d", st.total.ValueAtPercentile(50)),
+				fmt.Sprintf("%d", st.total.ValueAtPercentile(95)),
+				fmt.Sprintf("%d", st.total.ValueAtPercentile(99)),
+				fmt.Sprintf("%d", st.total.Max()),
+				fmt.Sprintf("%d", st.wait.ValueAtPercentile(50)),
+				fmt.Sprintf("%d", st.wait.ValueAtPercentile(95)),
+				fmt.Sprintf("%d", st.wait.ValueAtPercentile(99)),
+			})
+		}
+		return formatMarkdownTable(headers, right, rows)
+	}
+	groupScope := "all"
+	if coldSplit {
+		groupScope = "warm"
+	}
+	if len(lanes) > 0 {
+		fmt.Fprintf(&buf, "\n### Per Lane (%s, ms)\n\n", groupScope)
+		buf.WriteString(groupTable("lane", func(m iterMetric) string { return m.Lane }))
+	}
+	if shards > 0 {
+		fmt.Fprintf(&buf, "\n### Per Shard (%s, ms)\n\n", groupScope)
+		buf.WriteString(groupTable("shard", func(m iterMetric) string { return m.Shard }))
+	}
+
+	// 扇出：各迭代的分支耗时分布（以执行开始为基准到 Worker 发起回调），stragglerMs 为最慢分支比中位数慢的时间。
+	if len(fanouts) > 0 {
+		buf.WriteString("\n### Fan-out Branches (ms)\n\n")
+		fanoutHeaders := []string{"iter", "branches", "minMs", "avgMs", "p50Ms", "maxMs", "stragglerBranch", "stragglerMs"}
+		fanoutRight := []bool{true, true, true, true, true, true, true, true}
+		fanoutRows := make([][]string, 0, len(fanouts))
+		for i, f := range fanouts {
+			fanoutRows = append(fanoutRows, []string{
+				fmt.Sprintf("%d", i+1),
+				fmt.Sprintf("%d", f.Branches),
+				fmt.Sprintf("%d", f.MinMs),
+				fmt.Sprintf("%.3f", f.AvgMs),
+				fmt.Sprintf("%d", f.P50Ms),
+				fmt.Sprintf("%d", f.MaxMs),
+				fmt.Sprintf("%d", f.StragglerBranch),
+				fmt.Sprintf("%d", f.StragglerMs),
+			})
+		}
+		buf.WriteString(formatMarkdownTable(fanoutHeaders, fanoutRight, fanoutRows))
+	}
+
+	// 开环：按结果分组的延迟（计划发送时间到收到响应，已校正 coordinated omission）；
+	// "ok (uncorrected)" 为同一批成功请求从实际发出算起的耗时，两者的差距即客户端调度滞后被隐藏的部分。
+	if openLoop {
+		series := map[string][]int64{}
+		for _, r := range results {
+			series[r.outcome] = append(series[r.outcome], r.correctedMs)
+			if r.ok {
+				series["ok (uncorrected)"] = append(series["ok (uncorrected)"], r.wallMs)
+			}
+		}
+		buf.WriteString("\n### Open-loop Outcomes (ms)\n\n")
+		outcomeHeaders := []string{"outcome", "n", "avgMs", "p50Ms", "p99Ms", "maxMs"}
+		outcomeRight := []bool{false, true, true, true, true, true}
+		outcomeRows := [][]string{}
+		for _, name := range []string{outcomeOK, "ok (uncorrected)", outcomeTimeout, outcomeError} {
+			vals := series[name]
+			if len(vals) == 0 {
+				outcomeRows = append(outcomeRows, []string{name, "0", "n/a", "n/a", "n/a", "n/a"})
+				continue
+			}
+			h := &latencyHistogram{}
+			for _, v := range vals {
+				h.Record(v)
+			}
+			outcomeRows = append(outcomeRows, []string{
+				name,
+				fmt.Sprintf("%d", h.Count()),
+				fmt.Sprintf("%.3f", h.Mean()),
+				fmt.Sprintf("%d", h.ValueAtPercentile(50)),
+				fmt.Sprintf("%d", h.ValueAtPercentile(99)),
+				fmt.Sprintf("%d", h.Max()),
+			})
+		}
+		buf.WriteString(formatMarkdownTable(outcomeHeaders, outcomeRight, outcomeRows))
+	}
+
+	// 基线对比：闭环时两边都只比较 warm 迭代（排除第 1 次），开环时比较全部成功的请求；
+	// 分位数变慢超过阈值且 Mann-Whitney 检验显著时判定为回归。
+	var regressions []string
+	if baselinePath != "" {
+		baseRows, scope := baseline, "all"
+		if coldSplit {
+			baseRows, scope = warmRows(baseline), "warm"
+		}
+		table, regs := compareBaseline(baseRows, warm, thresholds, gatedColumns, alpha)
+		regressions = regs
+		fmt.Fprintf(&buf, "\n### Baseline Comparison (%s, ms)\n\nbaseline=%s alpha=%g thresholds=%s metrics=%s\n\n",
+			scope, baselinePath, alpha, getenvDefault("REGRESSION_THRESHOLDS", "p95:20"), strings.Join(gatedColumns, ","))
+		for _, w := range baselineWarnings {
+			fmt.Fprintf(&buf, "WARNING: %s\n\n", w)
+		}
+		buf.WriteString(table)
+	}
+
+	// 可选：RESULTS_DIR 写入结构化结果（summary.json + iterations.csv/jsonl），供 notebook/看板直接读取，不再解析 Markdown。
+	if dir := os.Getenv("RESULTS_DIR"); dir != "" {
+		summary := resultSummary{
+			Stack:            stackName,
+			Stage:            stage,
+			Region:           cfg.Region,
+			StateMachineArn:  stateMachineArn,
+			ApiEndpoint:      apiEndpoint,
+			StartedAt:        loadStart.UTC(),
+			FinishedAt:       loadStart.Add(loadElapsed).UTC(),
+			ElapsedMs:        loadElapsed.Milliseconds(),
+			Parameters:       parameters,
+			Scheduled:        repeat,
+			Succeeded:        len(metrics),
+			ThroughputPerSec: float64(len(metrics)) / loadElapsed.Seconds(),
+			Warm:             map[string]histogramStats{},
+			All:              map[string]histogramStats{},
+			Fanout:           fanouts,
+			Baseline:         baselinePath,
+			Regressions:      regressions,
+		}
+		summary.GitCommit, summary.GitDirty = gitRevision()
+		if openLoop {
+			summary.Outcomes = map[string]int{}
+			for _, r := range results {
+				summary.Outcomes[r.outcome]++
+			}
+		}
+		for c, h := range columnHistograms(warm) {
+			summary.Warm[summaryColumns[c]] = h.Stats()
+		}
+		for c, h := range columnHistograms(metrics) {
+			summary.All[summaryColumns[c]] = h.Stats()
+		}
+		out, err := writeResults(dir, summary, metrics)
+		if err != nil {
+			t.Fatalf("write results: %v", err)
+		}
+		fmt.Fprintf(&buf, "\nresults=%s\n", out)
 	}
-	buf.WriteString(formatMarkdownTable(summaryHeaders, summaryRight, allRows))
 
 	// 这两个标记用于 tests.sh 提取内容写入 result.md。
 	fmt.Println("===BEGIN_RESULT_MD===")
@@ -423,6 +898,107 @@ func TestStepFunctionsFlowLatency(t *testing.T) {
 		fmt.Println()
 	}
 	fmt.Println("===END_RESULT_MD===")
+
+	for _, r := range regressions {
+		t.Errorf("latency regression vs baseline: %s", r)
+	}
+}
+
+// resultSummary 是 RESULTS_DIR 下 summary.json 的内容：运行元数据、参数与各分段的统计（毫秒）。
+type resultSummary struct {
+	Stack           string    `json:"stack"`
+	Stage           string    `json:"stage"`
+	Region          string    `json:"region"`
+	GitCommit       string    `json:"gitCommit,omitempty"`
+	GitDirty        bool      `json:"gitDirty,omitempty"`
+	StateMachineArn string    `json:"stateMachineArn"`
+	ApiEndpoint     string    `json:"apiEndpoint"`
+	StartedAt       time.Time `json:"startedAt"`
+	FinishedAt      time.Time `json:"finishedAt"`
+	ElapsedMs       int64     `json:"elapsedMs"`
+
+	Parameters map[string]any `json:"parameters"`
+
+	Scheduled        int            `json:"scheduled"`
+	Succeeded        int            `json:"succeeded"`
+	Outcomes         map[string]int `json:"outcomes,omitempty"`
+	ThroughputPerSec float64        `json:"throughputPerSec"`
+
+	// Warm：排除冷启动（闭环为 iter=2..N，开环不区分冷启动，与 All 相同）；All：全部迭代。键为 summaryColumns 中的列名。
+	Warm   map[string]histogramStats `json:"warm"`
+	All    map[string]histogramStats `json:"all"`
+	Fanout []fanoutSummary           `json:"fanout,omitempty"`
+
+	// Baseline / Regressions：对比的基线与判定为回归的指标（见 compareBaseline）。
+	Baseline    string   `json:"baseline,omitempty"`
+	Regressions []string `json:"regressions,omitempty"`
+}
+
+// writeResults 在 dir 下按开始时间创建本次运行的目录（例如 20261016T120000Z），写入 summary.json、iterations.csv 与 iterations.jsonl，返回该目录。
+func writeResults(dir string, summary resultSummary, rows []iterMetric) (string, error) {
+	out := filepath.Join(dir, summary.StartedAt.Format("20060102T150405Z"))
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return "", err
+	}
+
+	b, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal summary: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "summary.json"), append(b, '\n'), 0o644); err != nil {
+		return "", err
+	}
+
+	var jsonl bytes.Buffer
+	for _, m := range rows {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return "", fmt.Errorf("marshal iteration %d: %w", m.Iter, err)
+		}
+		jsonl.Write(b)
+		jsonl.WriteByte('\n')
+	}
+	if err := os.WriteFile(filepath.Join(out, "iterations.jsonl"), jsonl.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+
+	var csvBuf bytes.Buffer
+	w := csv.NewWriter(&csvBuf)
+	_ = w.Write([]string{"iter", "runId", "executionArn", "lane", "shard", "totalMs", "sendToSqsMs", "sqsWaitMs", "workerMs", "overheadMs", "wallMs", "apiLambdaMs"})
+	for _, m := range rows {
+		_ = w.Write([]string{
+			strconv.Itoa(m.Iter), m.RunID, m.ExecutionArn, m.Lane, m.Shard,
+			strconv.FormatInt(m.TotalMs, 10),
+			strconv.FormatInt(m.SendToSqsMs, 10),
+			strconv.FormatInt(m.SqsWaitMs, 10),
+			strconv.FormatInt(m.WorkerMs, 10),
+			strconv.FormatInt(m.OverheadMs, 10),
+			strconv.FormatInt(m.WallMs, 10),
+			strconv.FormatInt(m.ApiLambdaMs, 10),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(out, "iterations.csv"), csvBuf.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// gitRevision 返回当前代码的 commit 与工作区是否有未提交改动；GIT_COMMIT 环境变量（例如 CI 中）优先。
+// 不在 git 仓库中或没有 git 时返回空。
+func gitRevision() (string, bool) {
+	if c := strings.TrimSpace(os.Getenv("GIT_COMMIT")); c != "" {
+		return c, false
+	}
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false
+	}
+	status, _ := exec.Command("git", "status", "--porcelain").Output()
+	return strings.TrimSpace(string(out)), len(bytes.TrimSpace(status)) > 0
 }
 
 func formatMarkdownTable(headers []string, rightAlign []bool, rows [][]string) string {
@@ -523,6 +1099,489 @@ func resolveStackOutput(ctx context.Context, cfg aws.Config, stackName string, o
 	return "", fmt.Errorf("output %q not found in stack: %s", outputKey, stackName)
 }
 
+// reportPercentiles 是摘要表输出的分位数。
+var reportPercentiles = []float64{50, 90, 95, 99, 99.9}
+
+// summaryColumns 是摘要表统计的分段列，与 columnHistograms 的顺序一致。
+var summaryColumns = []string{"totalMs", "sendToSqsMs", "sqsWaitMs", "workerMs", "overheadMs"}
+
+// columnHistograms 为每个分段列（summaryColumns）构建直方图。
+func columnHistograms(ms []iterMetric) []*latencyHistogram {
+	cols := []func(iterMetric) int64{
+		func(m iterMetric) int64 { return m.TotalMs },
+		func(m iterMetric) int64 { return m.SendToSqsMs },
+		func(m iterMetric) int64 { return m.SqsWaitMs },
+		func(m iterMetric) int64 { return m.WorkerMs },
+		func(m iterMetric) int64 { return m.OverheadMs },
+	}
+	hists := make([]*latencyHistogram, len(cols))
+	for c, col := range cols {
+		hists[c] = &latencyHistogram{}
+		for _, m := range ms {
+			hists[c].Record(col(m))
+		}
+	}
+	return hists
+}
+
+func percentileLabel(q float64) string {
+	return "p" + strconv.FormatFloat(q, 'f', -1, 64)
+}
+
+// histSubBucketBits 决定直方图精度：每个 2 的幂区间分为 2^(histSubBucketBits-1) 个等宽子桶，相对误差约 0.1%。
+const histSubBucketBits = 11
+
+// latencyHistogram 是 HDR 风格的对数-线性直方图（毫秒，非负整数）：小于 2^histSubBucketBits 的值精确计数，
+// 更大的值按 2 的幂分段、段内等宽分桶，桶数随数量级对数增长，长时间开环压测也不需要保存全部样本。
+// 均值与标准差由精确的累计和计算；分位数返回所在桶的最大等价值（不超过实际最大值）。
+type latencyHistogram struct {
+	counts     []int64
+	n          int64
+	sum, sumSq float64
+	min, max   int64
+}
+
+func histBucketIndex(v int64) int {
+	sub := int64(1) << histSubBucketBits
+	if v < sub {
+		return int(v)
+	}
+	half := sub / 2
+	shift := bits.Len64(uint64(v)) - histSubBucketBits
+	return int(sub + int64(shift-1)*half + v>>shift - half)
+}
+
+// histBucketRange 返回桶 idx 覆盖的最小与最大值。
+func histBucketRange(idx int) (int64, int64) {
+	sub := 1 << histSubBucketBits
+	if idx < sub {
+		return int64(idx), int64(idx)
+	}
+	half := sub / 2
+	shift := (idx-sub)/half + 1
+	lower := int64((idx-sub)%half+half) << shift
+	return lower, lower + int64(1)<<shift - 1
+}
+
+func (h *latencyHistogram) Record(v int64) {
+	v = max(v, 0)
+	idx := histBucketIndex(v)
+	if idx >= len(h.counts) {
+		h.counts = append(h.counts, make([]int64, idx+1-len(h.counts))...)
+	}
+	h.counts[idx]++
+	if h.n == 0 || v < h.min {
+		h.min = v
+	}
+	h.max = max(h.max, v)
+	h.n++
+	h.sum += float64(v)
+	h.sumSq += float64(v) * float64(v)
+}
+
+func (h *latencyHistogram) Count() int64 { return h.n }
+func (h *latencyHistogram) Min() int64   { return h.min }
+func (h *latencyHistogram) Max() int64   { return h.max }
+
+func (h *latencyHistogram) Mean() float64 {
+	if h.n == 0 {
+		return 0
+	}
+	return h.sum / float64(h.n)
+}
+
+// StdDev 返回总体标准差。
+func (h *latencyHistogram) StdDev() float64 {
+	if h.n == 0 {
+		return 0
+	}
+	mean := h.Mean()
+	return math.Sqrt(max(h.sumSq/float64(h.n)-mean*mean, 0))
+}
+
+// ValueAtPercentile 返回第 p 百分位（nearest-rank）所在桶的最大等价值。
+func (h *latencyHistogram) ValueAtPercentile(p float64) int64 {
+	if h.n == 0 {
+		return 0
+	}
+	target := max(int64(math.Ceil(p/100*float64(h.n))), 1)
+	var cum int64
+	for idx, c := range h.counts {
+		cum += c
+		if cum >= target {
+			_, hi := histBucketRange(idx)
+			return min(hi, h.max)
+		}
+	}
+	return h.max
+}
+
+// histogramStats 是直方图的统计摘要（写入 summary.json）。
+type histogramStats struct {
+	Count       int64            `json:"count"`
+	Mean        float64          `json:"mean"`
+	StdDev      float64          `json:"stddev"`
+	Min         int64            `json:"min"`
+	Max         int64            `json:"max"`
+	Percentiles map[string]int64 `json:"percentiles"`
+}
+
+func (h *latencyHistogram) Stats() histogramStats {
+	st := histogramStats{Count: h.n, Mean: h.Mean(), StdDev: h.StdDev(), Min: h.min, Max: h.max, Percentiles: map[string]int64{}}
+	for _, q := range reportPercentiles {
+		st.Percentiles[percentileLabel(q)] = h.ValueAtPercentile(q)
+	}
+	return st
+}
+
+// MarkdownBars 把直方图按 1-2-5 刻度（0,1,2,5,10,20,50,…）合并为显示区间，输出带 ASCII 条形的 Markdown 表格；
+// 条形长度按最多的区间缩放到 width 个字符。
+func (h *latencyHistogram) MarkdownBars(width int) string {
+	headers := []string{"rangeMs", "n", "histogram"}
+	right := []bool{false, true, false}
+	if h.n == 0 {
+		return formatMarkdownTable(headers, right, [][]string{{"n/a", "0", ""}})
+	}
+	edges := []int64{0}
+	for base := int64(1); edges[len(edges)-1] <= h.max; base *= 10 {
+		edges = append(edges, base, 2*base, 5*base)
+	}
+	counts := make([]int64, len(edges))
+	for idx, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		lo, _ := histBucketRange(idx)
+		// 最后一个不超过 lo 的刻度即所在区间。
+		i := sort.Search(len(edges), func(i int) bool { return edges[i] > lo }) - 1
+		counts[i] += c
+	}
+	first, last := -1, 0
+	var peak int64
+	for i, c := range counts {
+		if c > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+			peak = max(peak, c)
+		}
+	}
+	rows := make([][]string, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		bar := ""
+		if counts[i] > 0 {
+			bar = strings.Repeat("#", max(int(counts[i]*int64(width)/peak), 1))
+		}
+		rows = append(rows, []string{fmt.Sprintf("[%d, %d)", edges[i], edges[i+1]), fmt.Sprintf("%d", counts[i]), bar})
+	}
+	return formatMarkdownTable(headers, right, rows)
+}
+
+// regressionThreshold 是一条回归门限：第 Percentile 百分位相对基线变慢超过 MaxIncreasePct（%），
+// 或 Absolute 时比基线慢超过 MaxIncreaseMs 毫秒（基线为 0 的列也能判定）。
+type regressionThreshold struct {
+	Percentile     float64
+	MaxIncreasePct float64
+	MaxIncreaseMs  int64
+	Absolute       bool
+}
+
+// exceeded 判断分位数从 base 变为 cur 是否超过门限，并返回变化量的描述（例如 "+25.0% > +20%"）。
+func (th regressionThreshold) exceeded(base, cur int64) (bool, string) {
+	if cur <= base {
+		return false, ""
+	}
+	if th.Absolute {
+		return cur-base > th.MaxIncreaseMs, fmt.Sprintf("+%dms > +%dms", cur-base, th.MaxIncreaseMs)
+	}
+	if base <= 0 {
+		return false, ""
+	}
+	increase := float64(cur-base) / float64(base) * 100
+	return increase > th.MaxIncreasePct, fmt.Sprintf("+%.1f%% > +%g%%", increase, th.MaxIncreasePct)
+}
+
+// parseThresholds 解析 REGRESSION_THRESHOLDS，例如 "p95:20,p99:30%,p50:15ms"：
+// 数字或带 % 后缀为相对基线的百分比，带 ms 后缀为绝对毫秒数。
+func parseThresholds(v string) ([]regressionThreshold, error) {
+	var out []regressionThreshold
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, pct, ok := strings.Cut(part, ":")
+		if !ok || !strings.HasPrefix(name, "p") {
+			return nil, fmt.Errorf("invalid threshold %q (want pNN:percent)", part)
+		}
+		q, err := strconv.ParseFloat(strings.TrimPrefix(name, "p"), 64)
+		if err != nil || q <= 0 || q > 100 {
+			return nil, fmt.Errorf("invalid percentile in %q", part)
+		}
+		pct = strings.TrimSpace(pct)
+		if ms, ok := strings.CutSuffix(pct, "ms"); ok {
+			maxMs, err := strconv.ParseInt(strings.TrimSpace(ms), 10, 64)
+			if err != nil || maxMs < 0 {
+				return nil, fmt.Errorf("invalid milliseconds in %q", part)
+			}
+			out = append(out, regressionThreshold{Percentile: q, MaxIncreaseMs: maxMs, Absolute: true})
+			continue
+		}
+		maxPct, err := strconv.ParseFloat(strings.TrimSuffix(pct, "%"), 64)
+		if err != nil || maxPct < 0 {
+			return nil, fmt.Errorf("invalid percent in %q", part)
+		}
+		out = append(out, regressionThreshold{Percentile: q, MaxIncreasePct: maxPct})
+	}
+	return out, nil
+}
+
+// loadBaseline 读取此前运行写出的 iterations.jsonl（path 为结果目录时读取其中的 iterations.jsonl），按 iter 排序；
+// 同时读取同一目录下 summary.json 中的压测参数（没有 summary.json 时为 nil）。
+func loadBaseline(path string) ([]iterMetric, map[string]any, error) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, "iterations.jsonl")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows []iterMetric
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var m iterMetric
+		if err := json.Unmarshal(line, &m); err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		rows = append(rows, m)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%s: no iterations", path)
+	}
+	sort.Slice(rows, func(a, b int) bool { return rows[a].Iter < rows[b].Iter })
+
+	summaryPath := filepath.Join(filepath.Dir(path), "summary.json")
+	b, err = os.ReadFile(summaryPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return rows, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var summary resultSummary
+	if err := json.Unmarshal(b, &summary); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", summaryPath, err)
+	}
+	if summary.Parameters == nil {
+		return nil, nil, fmt.Errorf("%s: no parameters", summaryPath)
+	}
+	return rows, summary.Parameters, nil
+}
+
+// baselineParameterKeys 是与基线对比前必须一致的压测参数：任一不同时两次运行的延迟分布不可比
+// （例如开环的 totalMs 是校正后的延迟，闭环是 API 返回的耗时）。repeat/duration 只影响样本数，不参与比较。
+var baselineParameterKeys = []string{
+	"mode", "totalMs", "transport", "lanes", "fifo", "fanout", "workMs", "taskType",
+	"messageBodyBytes", "contentMode", "compression", "completionMode", "workflowType",
+}
+
+// baselineMismatches 返回基线与本次取值不同的参数（"key: base -> cur"）。负载强度按本次的模式比较：闭环比较 concurrency，开环比较 rate。
+// 取值按 JSON 编码比较，summary.json 读回的 float64/[]any 与本次的 int/[]string 可以直接对比。
+func baselineMismatches(base, cur map[string]any) []string {
+	keys := slices.Clone(baselineParameterKeys)
+	if cur["mode"] == "open-loop" {
+		keys = append(keys, "rate")
+	} else {
+		keys = append(keys, "concurrency")
+	}
+	var diffs []string
+	for _, k := range keys {
+		b, _ := json.Marshal(base[k])
+		c, _ := json.Marshal(cur[k])
+		if !bytes.Equal(b, c) {
+			diffs = append(diffs, fmt.Sprintf("%s: %s -> %s", k, b, c))
+		}
+	}
+	return diffs
+}
+
+// warmRows 排除第 1 次迭代（冷启动）；只有 1 次迭代时原样返回。
+func warmRows(rows []iterMetric) []iterMetric {
+	if len(rows) > 1 {
+		return rows[1:]
+	}
+	return rows
+}
+
+// comparePercentiles 是基线对比表固定展示的分位数（门限中的其它分位数会追加展示）。
+var comparePercentiles = []float64{50, 95, 99}
+
+// compareBaseline 逐列（summaryColumns）对比基线与本次的分位数，输出 Markdown 表，并返回判定为回归的描述。
+// 只有 gated 中的列参与判定：某个门限分位数变慢超过门限（见 regressionThreshold），且 Mann-Whitney U 检验的单侧 p 值（本次更慢）小于 alpha。
+// 显著性检验避免少量迭代的随机抖动触发失败；基线分位数为 0 时无法计算变化比例，只有绝对门限参与判定。
+func compareBaseline(base, cur []iterMetric, thresholds []regressionThreshold, gated []string, alpha float64) (string, []string) {
+	qs := append([]float64{}, comparePercentiles...)
+	for _, th := range thresholds {
+		if !slices.Contains(qs, th.Percentile) {
+			qs = append(qs, th.Percentile)
+		}
+	}
+	headers := []string{"metric", "nBase", "nCur"}
+	right := []bool{false, true, true}
+	for _, q := range qs {
+		headers = append(headers, percentileLabel(q)+" base->cur")
+		right = append(right, true)
+	}
+	headers = append(headers, "P(cur>base)", "mannWhitneyP", "gate")
+	right = append(right, true, true, false)
+
+	baseHists, curHists := columnHistograms(base), columnHistograms(cur)
+	var regressions []string
+	rows := make([][]string, 0, len(summaryColumns))
+	for c, name := range summaryColumns {
+		bh, ch := baseHists[c], curHists[c]
+		row := []string{name, fmt.Sprintf("%d", bh.Count()), fmt.Sprintf("%d", ch.Count())}
+		for _, q := range qs {
+			b, v := bh.ValueAtPercentile(q), ch.ValueAtPercentile(q)
+			row = append(row, fmt.Sprintf("%d -> %d (%s)", b, v, formatDeltaPct(b, v)))
+		}
+		p, superiority := mannWhitneyU(columnValues(base, c), columnValues(cur, c))
+		row = append(row, fmt.Sprintf("%.3f", superiority), fmt.Sprintf("%.4f", p))
+
+		gate := "-"
+		if slices.Contains(gated, name) {
+			gate = "ok"
+			for _, th := range thresholds {
+				b, v := bh.ValueAtPercentile(th.Percentile), ch.ValueAtPercentile(th.Percentile)
+				if exceeded, delta := th.exceeded(b, v); exceeded && p < alpha {
+					gate = "FAIL"
+					regressions = append(regressions, fmt.Sprintf("%s %s %d->%d ms (%s, Mann-Whitney p=%.4f)",
+						name, percentileLabel(th.Percentile), b, v, delta, p))
+				}
+			}
+		}
+		rows = append(rows, append(row, gate))
+	}
+	return formatMarkdownTable(headers, right, rows), regressions
+}
+
+// columnValues 取出第 c 个分段列（与 columnHistograms 的顺序一致）的原始样本。
+func columnValues(rows []iterMetric, c int) []int64 {
+	vals := make([]int64, 0, len(rows))
+	for _, m := range rows {
+		vals = append(vals, []int64{m.TotalMs, m.SendToSqsMs, m.SqsWaitMs, m.WorkerMs, m.OverheadMs}[c])
+	}
+	return vals
+}
+
+func formatDeltaPct(base, cur int64) string {
+	if base <= 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", float64(cur-base)/float64(base)*100)
+}
+
+// mannWhitneyU 对 base 与 cur 做 Mann-Whitney U 检验（正态近似，含并列校正与连续性校正），
+// 返回单侧 p 值（备择假设：cur 比 base 慢，即 cur 的取值随机地偏大）与 cur 的优势概率 P(cur>base)+0.5·P(cur=base)
+// （0.5 表示两者分布相当，越大表示本次越慢）。门禁只关心变慢，本次明显更快时 p 接近 1，不会判定为回归。
+func mannWhitneyU(base, cur []int64) (float64, float64) {
+	n1, n2 := float64(len(cur)), float64(len(base))
+	if n1 == 0 || n2 == 0 {
+		return 1, 0.5
+	}
+	type sample struct {
+		v   int64
+		cur bool
+	}
+	all := make([]sample, 0, len(base)+len(cur))
+	for _, v := range cur {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range base {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(a, b int) bool { return all[a].v < all[b].v })
+
+	// 平均秩（并列取平均），同时累计并列校正项 Σ(t³-t)。
+	var rankSumCur, tieTerm float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].cur {
+				rankSumCur += rank
+			}
+		}
+		t := float64(j - i)
+		tieTerm += t*t*t - t
+		i = j
+	}
+	u := rankSumCur - n1*(n1+1)/2
+	superiority := u / (n1 * n2)
+
+	n := n1 + n2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1))))
+	if sigma == 0 {
+		return 1, superiority
+	}
+	z := (u - mu - 0.5) / sigma
+	return math.Erfc(z/math.Sqrt2) / 2, superiority
+}
+
+// 开环模式的请求结果分类。
+const (
+	outcomeOK      = "ok"
+	outcomeTimeout = "timeout"
+	outcomeError   = "error"
+)
+
+// classifyOutcome 把请求结果分为 ok/timeout/error：API 的 504（status=TIMEOUT）与客户端超时都算 timeout。
+func classifyOutcome(out apiResponse, err error) string {
+	if err == nil {
+		return outcomeOK
+	}
+	var statusErr *apiStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusGatewayTimeout {
+		return outcomeTimeout
+	}
+	var netErr net.Error
+	if out.Status == "TIMEOUT" || errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return outcomeTimeout
+	}
+	return outcomeError
+}
+
+// parseRate 解析 RATE（例如 "50/s"、"3000/m" 或 "50"，单位默认每秒），返回每秒请求数；为空时返回 0。
+func parseRate(v string) (float64, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, nil
+	}
+	per := time.Second
+	if n, unit, ok := strings.Cut(v, "/"); ok {
+		switch strings.TrimSpace(unit) {
+		case "s":
+		case "m":
+			per = time.Minute
+		default:
+			return 0, fmt.Errorf("unknown unit %q (want s or m)", unit)
+		}
+		v = n
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", v)
+	}
+	return n / per.Seconds(), nil
+}
+
 func getenvDefault(key, def string) string {
 	v := os.Getenv(key)
 	if v == "" {
@@ -542,3 +1601,517 @@ func getenvIntDefault(key string, def int) int {
 	}
 	return n
 }
+
+// 以下为报告辅助函数的离线测试，不需要 RUN_REMOTE_TESTS。
+
+// markdownTableRows 解析 formatMarkdownTable 的输出，返回去掉表头与分隔行、各单元格已去除空白的数据行。
+func markdownTableRows(md string) [][]string {
+	var rows [][]string
+	lines := strings.Split(strings.TrimSpace(md), "\n")
+	for _, line := range lines[min(2, len(lines)):] {
+		cells := strings.Split(strings.Trim(line, "|"), "|")
+		for i := range cells {
+			cells[i] = strings.TrimSpace(cells[i])
+		}
+		rows = append(rows, cells)
+	}
+	return rows
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	sub := int64(1) << histSubBucketBits
+	cases := []struct {
+		v     int64
+		exact bool
+	}{
+		{v: 0, exact: true},
+		{v: 1, exact: true},
+		{v: sub - 1, exact: true},
+		{v: sub},
+		{v: sub + 1},
+		{v: sub + 2},
+		{v: 2*sub - 1},
+		{v: 2 * sub},
+		{v: 2*sub + 3},
+		{v: 4*sub - 1},
+		{v: 4 * sub},
+		{v: 1 << 20},
+		{v: 1<<20 + 12345},
+		{v: 3_600_000},
+		{v: 1 << 40},
+	}
+	for _, tc := range cases {
+		idx := histBucketIndex(tc.v)
+		lo, hi := histBucketRange(idx)
+		if tc.v < lo || tc.v > hi {
+			t.Errorf("v=%d: bucket %d covers [%d, %d]", tc.v, idx, lo, hi)
+		}
+		if histBucketIndex(lo) != idx || histBucketIndex(hi) != idx {
+			t.Errorf("v=%d: bucket %d bounds [%d, %d] map to %d/%d", tc.v, idx, lo, hi, histBucketIndex(lo), histBucketIndex(hi))
+		}
+		if tc.exact && (lo != tc.v || hi != tc.v) {
+			t.Errorf("v=%d: want exact bucket, got [%d, %d]", tc.v, lo, hi)
+		}
+		// 精确区之外的相对误差上限：桶宽不超过下界的 1/2^(histSubBucketBits-1)。
+		if width := hi - lo + 1; !tc.exact && width*(sub/2) > lo {
+			t.Errorf("v=%d: bucket [%d, %d] wider than 1/%d of its lower bound", tc.v, lo, hi, sub/2)
+		}
+	}
+
+	// 桶首尾相接、覆盖所有值：跨过 2048 精确区与各个 2 的幂边界。
+	var prevHi int64 = -1
+	for idx := 0; idx < int(4*sub); idx++ {
+		lo, hi := histBucketRange(idx)
+		if lo != prevHi+1 || hi < lo {
+			t.Fatalf("bucket %d = [%d, %d], previous ended at %d", idx, lo, hi, prevHi)
+		}
+		prevHi = hi
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	empty := &latencyHistogram{}
+	if empty.Count() != 0 || empty.ValueAtPercentile(99) != 0 || empty.Mean() != 0 || empty.StdDev() != 0 {
+		t.Fatalf("empty histogram: count=%d p99=%d mean=%v stddev=%v", empty.Count(), empty.ValueAtPercentile(99), empty.Mean(), empty.StdDev())
+	}
+
+	cases := []struct {
+		name    string
+		samples []int64
+	}{
+		{name: "single", samples: []int64{42}},
+		{name: "exact range", samples: []int64{5, 1, 4, 2, 3, 2047, 0}},
+		{name: "around 2048", samples: []int64{2046, 2047, 2048, 2049, 2050, 2051, 4095, 4096, 4097}},
+		{name: "long tail", samples: func() []int64 {
+			var s []int64
+			for i := int64(1); i <= 5000; i++ {
+				s = append(s, 20+i*i%977)
+			}
+			return append(s, 30_000, 125_000, 900_000)
+		}()},
+		{name: "negative clamps to zero", samples: []int64{-5, 10, 20}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &latencyHistogram{}
+			sorted := make([]int64, 0, len(tc.samples))
+			var sum float64
+			for _, v := range tc.samples {
+				h.Record(v)
+				sorted = append(sorted, max(v, 0))
+				sum += float64(max(v, 0))
+			}
+			slices.Sort(sorted)
+			if h.Count() != int64(len(sorted)) || h.Min() != sorted[0] || h.Max() != sorted[len(sorted)-1] {
+				t.Fatalf("count/min/max = %d/%d/%d, want %d/%d/%d", h.Count(), h.Min(), h.Max(), len(sorted), sorted[0], sorted[len(sorted)-1])
+			}
+			if want := sum / float64(len(sorted)); math.Abs(h.Mean()-want) > 1e-9 {
+				t.Fatalf("mean = %v, want %v", h.Mean(), want)
+			}
+			for _, q := range []float64{0, 1, 50, 90, 95, 99, 99.9, 100} {
+				// nearest-rank：第 ceil(q/100·n) 个样本（至少第 1 个）。
+				exact := sorted[max(int(math.Ceil(q/100*float64(len(sorted)))), 1)-1]
+				got := h.ValueAtPercentile(q)
+				// 直方图返回所在桶的最大等价值：不小于真实值、不超过最大值，且相对误差不超过 1/2^(histSubBucketBits-1)。
+				limit := exact + exact/(int64(1)<<(histSubBucketBits-1))
+				if got < exact || got > limit || got > h.Max() {
+					t.Errorf("p%v = %d, want within [%d, %d]", q, got, exact, limit)
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramMarkdownBars(t *testing.T) {
+	if rows := markdownTableRows((&latencyHistogram{}).MarkdownBars(10)); len(rows) != 1 || rows[0][0] != "n/a" || rows[0][1] != "0" {
+		t.Fatalf("empty histogram rows = %q", rows)
+	}
+
+	h := &latencyHistogram{}
+	for _, v := range []int64{0, 1, 1, 1, 1, 3, 7, 15, 150, 2048, 2049} {
+		h.Record(v)
+	}
+	want := [][]string{
+		{"[0, 1)", "1", "##"},
+		{"[1, 2)", "4", "##########"},
+		{"[2, 5)", "1", "##"},
+		{"[5, 10)", "1", "##"},
+		{"[10, 20)", "1", "##"},
+		{"[20, 50)", "0", ""},
+		{"[50, 100)", "0", ""},
+		{"[100, 200)", "1", "##"},
+		{"[200, 500)", "0", ""},
+		{"[500, 1000)", "0", ""},
+		{"[1000, 2000)", "0", ""},
+		{"[2000, 5000)", "2", "#####"},
+	}
+	if got := markdownTableRows(h.MarkdownBars(10)); !slices.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Fatalf("MarkdownBars rows:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestWriteResultsRoundTrip(t *testing.T) {
+	started := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	// 乱序写入：loadBaseline 需要按 iter 排回顺序；runId 带逗号与引号，检验 CSV 转义。
+	rows := []iterMetric{
+		{Iter: 2, RunID: "run-2", ExecutionArn: "arn:aws:states:us-east-1:123456789012:execution:sm:run-2", Lane: "high", TotalMs: 120, SendToSqsMs: 10, SqsWaitMs: 30, WorkerMs: 50, OverheadMs: 30, WallMs: 130, ApiLambdaMs: 118},
+		{Iter: 1, RunID: `run-1,"cold"`, ExecutionArn: "arn:aws:states:us-east-1:123456789012:execution:sm:run-1", TotalMs: 900, SendToSqsMs: 40, SqsWaitMs: 200, WorkerMs: 400, OverheadMs: 260, WallMs: 950, ApiLambdaMs: 880},
+		{Iter: 3, RunID: "run-3", Shard: "shardId-000000000001", TotalMs: 110, WallMs: 115},
+	}
+	h := &latencyHistogram{}
+	for _, r := range rows {
+		h.Record(r.TotalMs)
+	}
+	summary := resultSummary{
+		Stack:       "testsqs-dev",
+		Stage:       "dev",
+		StartedAt:   started,
+		FinishedAt:  started.Add(3 * time.Second),
+		ElapsedMs:   3000,
+		Parameters:  map[string]any{"mode": "closed-loop", "repeat": 3},
+		Scheduled:   3,
+		Succeeded:   3,
+		Warm:        map[string]histogramStats{},
+		All:         map[string]histogramStats{"totalMs": h.Stats()},
+		Regressions: []string{"totalMs p95 100->120 ms"},
+	}
+
+	dir := t.TempDir()
+	out, err := writeResults(dir, summary, rows)
+	if err != nil {
+		t.Fatalf("writeResults: %v", err)
+	}
+	if want := filepath.Join(dir, "20261016T120000Z"); out != want {
+		t.Fatalf("results dir = %s, want %s", out, want)
+	}
+
+	// summary.json：合法 JSON，读回后与写入的内容一致。
+	b, err := os.ReadFile(filepath.Join(out, "summary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotSummary resultSummary
+	if err := json.Unmarshal(b, &gotSummary); err != nil {
+		t.Fatalf("summary.json: %v", err)
+	}
+	if !gotSummary.StartedAt.Equal(started) || gotSummary.Stack != summary.Stack || gotSummary.Succeeded != 3 ||
+		!slices.Equal(gotSummary.Regressions, summary.Regressions) || gotSummary.Parameters["mode"] != "closed-loop" {
+		t.Fatalf("summary.json round trip = %+v", gotSummary)
+	}
+	if got, want := gotSummary.All["totalMs"].Percentiles["p99"], h.ValueAtPercentile(99); got != want {
+		t.Fatalf("summary.json all.totalMs p99 = %d, want %d", got, want)
+	}
+
+	// iterations.jsonl：每行一个合法 JSON 对象，顺序与写入一致。
+	b, err = os.ReadFile(filepath.Join(out, "iterations.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != len(rows) {
+		t.Fatalf("iterations.jsonl has %d lines, want %d", len(lines), len(rows))
+	}
+	for i, line := range lines {
+		var m iterMetric
+		if err := json.Unmarshal([]byte(line), &m); err != nil || m != rows[i] {
+			t.Fatalf("iterations.jsonl line %d = %s (err %v), want %+v", i+1, line, err, rows[i])
+		}
+	}
+
+	// iterations.csv：表头 + 每次迭代一行，列数一致，数值与字段可以原样解析回来。
+	f, err := os.Open(filepath.Join(out, "iterations.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("iterations.csv: %v", err)
+	}
+	if len(records) != len(rows)+1 || records[0][0] != "iter" || records[0][5] != "totalMs" {
+		t.Fatalf("iterations.csv = %q", records)
+	}
+	for i, rec := range records[1:] {
+		r := rows[i]
+		want := []string{strconv.Itoa(r.Iter), r.RunID, r.ExecutionArn, r.Lane, r.Shard,
+			strconv.FormatInt(r.TotalMs, 10), strconv.FormatInt(r.SendToSqsMs, 10), strconv.FormatInt(r.SqsWaitMs, 10),
+			strconv.FormatInt(r.WorkerMs, 10), strconv.FormatInt(r.OverheadMs, 10), strconv.FormatInt(r.WallMs, 10), strconv.FormatInt(r.ApiLambdaMs, 10)}
+		if !slices.Equal(rec, want) {
+			t.Fatalf("iterations.csv row %d = %q, want %q", i+1, rec, want)
+		}
+	}
+
+	// loadBaseline：结果目录与 iterations.jsonl 路径都能读回，且按 iter 排序；同时读回 summary.json 中的参数。
+	sorted := slices.Clone(rows)
+	slices.SortFunc(sorted, func(a, b iterMetric) int { return a.Iter - b.Iter })
+	for _, path := range []string{out, filepath.Join(out, "iterations.jsonl")} {
+		got, params, err := loadBaseline(path)
+		if err != nil {
+			t.Fatalf("loadBaseline(%s): %v", path, err)
+		}
+		if !slices.Equal(got, sorted) {
+			t.Fatalf("loadBaseline(%s) = %+v, want %+v", path, got, sorted)
+		}
+		if diffs := baselineMismatches(params, summary.Parameters); params["mode"] != "closed-loop" || len(diffs) > 0 {
+			t.Fatalf("loadBaseline(%s) parameters = %v (diffs %q), want %v", path, params, diffs, summary.Parameters)
+		}
+	}
+}
+
+func TestLoadBaselineErrors(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "empty.jsonl", content: "\n\n", want: "no iterations"},
+		{name: "bad.jsonl", content: `{"iter":1,"totalMs":10}` + "\nnot json\n", want: "bad.jsonl:2"},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := loadBaseline(path); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("loadBaseline(%s) error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+	if _, _, err := loadBaseline(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("loadBaseline(missing) succeeded")
+	}
+
+	// 没有 summary.json 时照常读取迭代，参数为 nil（由调用方警告）；summary.json 损坏或缺少 parameters 时报错。
+	run := filepath.Join(dir, "run")
+	if err := os.Mkdir(run, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(run, "iterations.jsonl"), []byte(`{"iter":1,"totalMs":10}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if rows, params, err := loadBaseline(run); err != nil || len(rows) != 1 || params != nil {
+		t.Errorf("loadBaseline without summary.json = %v, %v, %v; want 1 row and nil parameters", rows, params, err)
+	}
+	for _, summary := range []string{"not json", `{"stack":"testsqs-dev"}`} {
+		if err := os.WriteFile(filepath.Join(run, "summary.json"), []byte(summary), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := loadBaseline(run); err == nil || !strings.Contains(err.Error(), "summary.json") {
+			t.Errorf("loadBaseline with summary.json %q error = %v, want summary.json error", summary, err)
+		}
+	}
+}
+
+func TestBaselineMismatches(t *testing.T) {
+	closed := map[string]any{
+		"mode": "closed-loop", "totalMs": "api", "repeat": 50, "concurrency": 4, "rate": 0.0, "duration": "1m0s",
+		"transport": "sqs", "lanes": []string{"high", "low"}, "fifo": false, "fanout": 0, "workMs": 100, "taskType": "sleep",
+		"messageBodyBytes": 0, "contentMode": "", "compression": "", "completionMode": "", "workflowType": "",
+	}
+	// 基线参数来自 summary.json：经过一次 JSON 往返（数值变为 float64，切片变为 []any）。
+	roundTrip := func(m map[string]any) map[string]any {
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]any
+		if err := json.Unmarshal(b, &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	with := func(m map[string]any, kv ...any) map[string]any {
+		out := maps.Clone(m)
+		for i := 0; i < len(kv); i += 2 {
+			out[kv[i].(string)] = kv[i+1]
+		}
+		return out
+	}
+	open := with(closed, "mode", "open-loop", "totalMs", "corrected", "rate", 50.0)
+
+	cases := []struct {
+		name      string
+		base, cur map[string]any
+		want      []string
+	}{
+		{name: "identical", base: closed, cur: closed},
+		{name: "sample size ignored", base: closed, cur: with(closed, "repeat", 200, "duration", "5m0s")},
+		{name: "mode and basis", base: closed, cur: open, want: []string{`mode: "closed-loop" -> "open-loop"`, `totalMs: "api" -> "corrected"`, "rate: 0 -> 50"}},
+		{name: "workload", base: closed, cur: with(closed, "transport", "kinesis", "workMs", 0, "lanes", []string{"high"}),
+			want: []string{`transport: "sqs" -> "kinesis"`, `lanes: ["high","low"] -> ["high"]`, `workMs: 100 -> 0`}},
+		{name: "closed-loop concurrency", base: closed, cur: with(closed, "concurrency", 8), want: []string{"concurrency: 4 -> 8"}},
+		{name: "open-loop ignores concurrency", base: open, cur: with(open, "concurrency", 8)},
+		{name: "open-loop rate", base: open, cur: with(open, "rate", 100.0), want: []string{"rate: 50 -> 100"}},
+		{name: "missing key", base: with(closed, "totalMs", nil), cur: closed, want: []string{`totalMs: null -> "api"`}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := baselineMismatches(roundTrip(tc.base), tc.cur)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("baselineMismatches = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	seq := func(from, n int64) []int64 {
+		s := make([]int64, n)
+		for i := range s {
+			s[i] = from + int64(i)
+		}
+		return s
+	}
+	cases := []struct {
+		name            string
+		base, cur       []int64
+		wantSuperiority float64
+		minP, maxP      float64
+	}{
+		{name: "empty base", base: nil, cur: seq(1, 5), wantSuperiority: 0.5, minP: 1, maxP: 1},
+		{name: "empty cur", base: seq(1, 5), cur: nil, wantSuperiority: 0.5, minP: 1, maxP: 1},
+		{name: "all tied", base: []int64{7, 7, 7, 7}, cur: []int64{7, 7, 7}, wantSuperiority: 0.5, minP: 1, maxP: 1},
+		{name: "identical", base: seq(100, 20), cur: seq(100, 20), wantSuperiority: 0.5, minP: 0.5, maxP: 0.6},
+		// U=100，μ=50，σ=√175：z=(50-0.5)/√175≈3.742，单侧 p≈9.1e-5。
+		{name: "cur slower", base: seq(100, 10), cur: seq(200, 10), wantSuperiority: 1, minP: 8.5e-5, maxP: 9.6e-5},
+		// 本次更快：单侧检验不显著（z=(0-50-0.5)/√175≈-3.817）。
+		{name: "cur faster", base: seq(200, 10), cur: seq(100, 10), wantSuperiority: 0, minP: 0.9999, maxP: 1},
+		// 部分并列：cur 中的 3 与 base 中的 3 各算半次。P(cur>base)=(2+0.5+3+3)/9；z=(8.5-4.5-0.5)/√5.1≈1.55，p≈0.061。
+		{name: "partial ties", base: []int64{1, 2, 3}, cur: []int64{3, 4, 5}, wantSuperiority: 8.5 / 9, minP: 0.055, maxP: 0.065},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, sup := mannWhitneyU(tc.base, tc.cur)
+			if math.Abs(sup-tc.wantSuperiority) > 1e-9 {
+				t.Errorf("superiority = %v, want %v", sup, tc.wantSuperiority)
+			}
+			if p < tc.minP || p > tc.maxP {
+				t.Errorf("p = %v, want within [%v, %v]", p, tc.minP, tc.maxP)
+			}
+			// 交换两组：优势概率互补；单侧检验只对变慢的方向显著，本次较慢时交换后的 p 不小于 0.5。
+			pSwap, supSwap := mannWhitneyU(tc.cur, tc.base)
+			if len(tc.base) > 0 && len(tc.cur) > 0 && math.Abs(supSwap-(1-sup)) > 1e-9 {
+				t.Errorf("swapped superiority = %v, want %v", supSwap, 1-sup)
+			}
+			if sup > 0.5 && pSwap < 0.5 {
+				t.Errorf("swapped p = %v, want >= 0.5 when cur is slower", pSwap)
+			}
+		})
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    []regressionThreshold
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "p95:20", want: []regressionThreshold{{Percentile: 95, MaxIncreasePct: 20}}},
+		{in: "p95:20%", want: []regressionThreshold{{Percentile: 95, MaxIncreasePct: 20}}},
+		{in: " p50:12.5 , p99.9:0% ,", want: []regressionThreshold{{Percentile: 50, MaxIncreasePct: 12.5}, {Percentile: 99.9}}},
+		{in: "p95:50ms", want: []regressionThreshold{{Percentile: 95, MaxIncreaseMs: 50, Absolute: true}}},
+		{in: "p99:30%,p50: 15 ms", want: []regressionThreshold{{Percentile: 99, MaxIncreasePct: 30}, {Percentile: 50, MaxIncreaseMs: 15, Absolute: true}}},
+		{in: "p100:0ms", want: []regressionThreshold{{Percentile: 100, Absolute: true}}},
+		{in: "95:20", wantErr: true},
+		{in: "p95", wantErr: true},
+		{in: "p0:10", wantErr: true},
+		{in: "p101:10", wantErr: true},
+		{in: "p95:-5", wantErr: true},
+		{in: "p95:-5ms", wantErr: true},
+		{in: "p95:1.5ms", wantErr: true},
+		{in: "p95:abc", wantErr: true},
+		{in: "p95:10s", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := parseThresholds(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseThresholds(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("parseThresholds(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestCompareBaseline(t *testing.T) {
+	// rows 生成 n 次迭代：totalMs 从 total 起逐次加 1，overheadMs 固定为 overhead。
+	rows := func(n int, total, overhead int64) []iterMetric {
+		out := make([]iterMetric, n)
+		for i := range out {
+			out[i] = iterMetric{Iter: i + 1, TotalMs: total + int64(i), OverheadMs: overhead}
+		}
+		return out
+	}
+	mustThresholds := func(v string) []regressionThreshold {
+		th, err := parseThresholds(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return th
+	}
+	cases := []struct {
+		name       string
+		base, cur  []iterMetric
+		thresholds string
+		gated      []string
+		wantGates  map[string]string
+		wantRegs   []string
+	}{
+		{
+			name: "relative regression", base: rows(30, 1000, 0), cur: rows(30, 1300, 0), thresholds: "p95:20", gated: []string{"totalMs"},
+			wantGates: map[string]string{"totalMs": "FAIL", "overheadMs": "-"}, wantRegs: []string{"totalMs p95 1028->1328 ms (+29.2% > +20%"},
+		},
+		{
+			name: "relative within threshold", base: rows(30, 1000, 0), cur: rows(30, 1080, 0), thresholds: "p95:20", gated: []string{"totalMs"},
+			wantGates: map[string]string{"totalMs": "ok"},
+		},
+		{
+			name: "absolute catches what relative allows", base: rows(30, 1000, 0), cur: rows(30, 1080, 0), thresholds: "p95:20,p95:50ms", gated: []string{"totalMs"},
+			wantGates: map[string]string{"totalMs": "FAIL"}, wantRegs: []string{"totalMs p95 1028->1108 ms (+80ms > +50ms"},
+		},
+		{
+			name: "not significant", base: rows(3, 1000, 0), cur: rows(3, 1001, 0), thresholds: "p50:0", gated: []string{"totalMs"},
+			wantGates: map[string]string{"totalMs": "ok"},
+		},
+		{
+			name: "zero baseline relative skipped", base: rows(30, 1000, 0), cur: rows(30, 1000, 40), thresholds: "p50:10", gated: []string{"overheadMs"},
+			wantGates: map[string]string{"overheadMs": "ok"},
+		},
+		{
+			name: "zero baseline absolute", base: rows(30, 1000, 0), cur: rows(30, 1000, 40), thresholds: "p50:25ms", gated: []string{"overheadMs"},
+			wantGates: map[string]string{"overheadMs": "FAIL"}, wantRegs: []string{"overheadMs p50 0->40 ms (+40ms > +25ms"},
+		},
+		{
+			name: "ungated column ignored", base: rows(30, 1000, 0), cur: rows(30, 1300, 0), thresholds: "p95:20", gated: []string{"workerMs"},
+			wantGates: map[string]string{"totalMs": "-", "workerMs": "ok"},
+		},
+		{
+			name: "empty current run", base: rows(30, 1000, 0), cur: nil, thresholds: "p95:20,p95:1ms", gated: []string{"totalMs"},
+			wantGates: map[string]string{"totalMs": "ok"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			table, regs := compareBaseline(tc.base, tc.cur, mustThresholds(tc.thresholds), tc.gated, 0.05)
+			if len(regs) != len(tc.wantRegs) {
+				t.Fatalf("regressions = %q, want %d matching %q", regs, len(tc.wantRegs), tc.wantRegs)
+			}
+			for i, want := range tc.wantRegs {
+				if !strings.HasPrefix(regs[i], want) {
+					t.Errorf("regression[%d] = %q, want prefix %q", i, regs[i], want)
+				}
+			}
+			gates := map[string]string{}
+			for _, row := range markdownTableRows(table) {
+				gates[row[0]] = row[len(row)-1]
+			}
+			for metric, want := range tc.wantGates {
+				if gates[metric] != want {
+					t.Errorf("gate[%s] = %q, want %q\n%s", metric, gates[metric], want, table)
+				}
+			}
+		})
+	}
+}
@@ -53,11 +53,14 @@ export RUN_REMOTE_TESTS=1
 export STAGE
 export STACK_NAME
 export REPEAT
+# 结构化结果（summary.json + iterations.csv/jsonl）默认写入 results/<开始时间>/，可通过环境变量 RESULTS_DIR 覆盖。
+export RESULTS_DIR="${RESULTS_DIR:-$ROOT_DIR/results}"
 
 echo "RUN_REMOTE_TESTS=$RUN_REMOTE_TESTS"
 echo "STAGE=$STAGE"
 echo "STACK_NAME=$STACK_NAME"
 echo "REPEAT=$REPEAT"
+echo "RESULTS_DIR=$RESULTS_DIR"
 
 # 运行测试并捕获输出，便于将结果块自动写入 result.md。
 TMP_OUT="${TMPDIR:-/tmp}/testsqs-test-output.$$".log